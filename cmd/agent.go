@@ -0,0 +1,486 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/spf13/cobra"
+)
+
+const agentSockEnv = "TOKENDOKEY_AGENT_SOCK"
+
+const (
+	// Tokens expiring within this window are refreshed by the agent in the background
+	agentRefreshAhead   = 2 * time.Minute
+	agentCheckInterval  = 30 * time.Second
+	agentConnTimeout    = 2 * time.Minute
+	agentStartupTimeout = 5 * time.Second
+	agentValidityMargin = 30 * time.Second
+	// Longest wait before retrying a background refresh that failed
+	agentMaxRetryInterval = 30 * time.Minute
+)
+
+var errAgentUnavailable = errors.New("token agent is not available")
+
+// The platform cannot tell which user connected to the agent socket
+var errPeerUnknown = errors.New("peer credentials are not supported on this platform")
+
+type agentRequest struct {
+	Command string `json:"command"`
+	Client  string `json:"client,omitempty"`
+	Force   bool   `json:"force,omitempty"`
+}
+
+type agentResponse struct {
+	Token string `json:"token,omitempty"`
+	// Key of the encrypted token store, base64 encoded
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Exit code of the category of Error
+	ExitCode int `json:"exit_code,omitempty"`
+}
+
+// A client's token held by the agent, the mutex serializes refreshes of that client
+type agentEntry struct {
+	mu    sync.Mutex
+	token string
+	// Expiry of token, unknown when hasExpiry is false
+	expiry    time.Time
+	hasExpiry bool
+	// Background refreshes failed in a row, the next one is not tried before retryAt
+	failures int
+	retryAt  time.Time
+}
+
+// Whether the held token is usable for a while longer
+func (e *agentEntry) valid() bool {
+	return e.token != "" && (!e.hasExpiry || time.Until(e.expiry) > agentValidityMargin)
+}
+
+// Whether the held token is still the token cached in the store. Logout, login and init remove or
+// replace the cached token, the agent must not keep serving the previous one.
+func (e *agentEntry) current(clientName string) bool {
+	client, err := tokendokey.Load(clientName)
+	return err == nil && e.token != "" && client.AccessToken() == e.token
+}
+
+type tokenAgent struct {
+	mu      sync.Mutex
+	entries map[string]*agentEntry
+}
+
+var AgentCmd = &cobra.Command{
+	Use:   "agent [--socket=[socket_path]] [--foreground] [-k|--kill]",
+	Short: "Start a background agent holding tokens in memory and serving get-token over a Unix socket.",
+	Long: `Start a background agent, similar to ssh-agent, holding access tokens in memory.
+The agent refreshes tokens before they expire and serves get-token requests over a Unix socket
+that only the current user can access. The command prints the shell commands setting
+TOKENDOKEY_AGENT_SOCK, get-token uses the agent whenever that variable is set.
+An encrypted token store is unlocked once when the agent starts, other commands then get the key from the agent
+instead of asking for the passphrase.`,
+	Example: `  eval $(tokendokey agent)
+  tokendokey agent --foreground --socket=/run/user/1000/tokendokey.sock
+  tokendokey agent -k`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socketPath, _ := cmd.Flags().GetString("socket")
+		foreground, _ := cmd.Flags().GetBool("foreground")
+		kill, _ := cmd.Flags().GetBool("kill")
+		keyStdin, _ := cmd.Flags().GetBool("key-stdin")
+
+		if kill {
+			if socketPath == "" {
+				socketPath = os.Getenv(agentSockEnv)
+			}
+			if socketPath == "" {
+				return fmt.Errorf("%s is not set", agentSockEnv)
+			}
+			_, err := callAgent(socketPath, agentRequest{Command: "stop"})
+			if err != nil {
+				return fmt.Errorf("stopping agent: %w", err)
+			}
+			fmt.Printf("unset %s;\n", agentSockEnv)
+			fmt.Println("echo Agent stopped;")
+			return nil
+		}
+
+		if socketPath == "" {
+			socketDir, err := os.MkdirTemp("", "tokendokey-")
+			if err != nil {
+				return fmt.Errorf("creating agent socket folder: %w", err)
+			}
+			socketPath = filepath.Join(socketDir, "agent.sock")
+		}
+
+		if keyStdin {
+			err := readAgentKey()
+			if err != nil {
+				return fmt.Errorf("reading token store key: %w", err)
+			}
+		}
+		// Unlock the store before detaching, while the passphrase can still be asked for
+		key, err := defaultStoreKey()
+		if err != nil {
+			return fmt.Errorf("unlocking token store: %w", err)
+		}
+
+		if foreground {
+			err := runAgent(socketPath)
+			if err != nil {
+				return fmt.Errorf("running agent: %w", err)
+			}
+			return nil
+		}
+
+		pid, err := startAgentProcess(socketPath, key)
+		if err != nil {
+			return fmt.Errorf("starting agent: %w", err)
+		}
+		fmt.Printf("%s=%s; export %s;\n", agentSockEnv, socketPath, agentSockEnv)
+		fmt.Printf("echo Agent pid %d;\n", pid)
+		return nil
+	},
+}
+
+func init() {
+	AgentCmd.Flags().String("socket", "", "Path of the agent Unix socket (a new temporary folder when empty)")
+	AgentCmd.Flags().Bool("foreground", false, "Run the agent in the foreground instead of in the background")
+	AgentCmd.Flags().BoolP("kill", "k", false, "Stop the agent listening on TOKENDOKEY_AGENT_SOCK")
+	// Passes the key of the encrypted store from the starting to the detached agent
+	AgentCmd.Flags().Bool("key-stdin", false, "Read the token store key from stdin")
+	AgentCmd.Flags().MarkHidden("key-stdin")
+}
+
+// Run this executable again as a detached foreground agent and wait until it listens.
+// The key of an encrypted store is handed over on stdin, never in arguments or the environment.
+func startAgentProcess(socketPath string, key []byte) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	agentProcess := exec.Command(executable, "agent", "--foreground", "--socket", socketPath)
+	if key != nil {
+		agentProcess.Args = append(agentProcess.Args, "--key-stdin")
+		agentProcess.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	agentProcess.SysProcAttr = detachedProcAttr()
+	err = agentProcess.Start()
+	if err != nil {
+		return 0, err
+	}
+
+	deadline := time.Now().Add(agentStartupTimeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			return agentProcess.Process.Pid, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	agentProcess.Process.Kill()
+	return 0, fmt.Errorf("agent did not start listening on %s", socketPath)
+}
+
+// Serve token requests on socketPath until stopped
+func runAgent(socketPath string) error {
+	os.MkdirAll(filepath.Dir(socketPath), 0700)
+	// A stale socket of a previous agent is replaced, anything else at the path is kept
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		os.Remove(socketPath)
+	}
+
+	listener, err := listenSocket(socketPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socketPath)
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	agent := &tokenAgent{entries: map[string]*agentEntry{}}
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	stopAgent := func() { stopOnce.Do(func() { close(stop) }) }
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			stopAgent()
+		case <-stop:
+		}
+	}()
+	go func() {
+		<-stop
+		listener.Close()
+	}()
+	go agent.refreshLoop(stop)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+				return err
+			}
+		}
+		go agent.serve(conn, stopAgent)
+	}
+}
+
+func (a *tokenAgent) serve(conn net.Conn, stopAgent func()) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentConnTimeout))
+
+	// Tokens and the store key are only for processes of the agent's user
+	err := checkPeer(conn)
+	if err != nil {
+		json.NewEncoder(conn).Encode(agentResponse{Error: err.Error()})
+		return
+	}
+
+	var request agentRequest
+	err = json.NewDecoder(conn).Decode(&request)
+	if err != nil {
+		json.NewEncoder(conn).Encode(agentResponse{Error: "invalid request: " + err.Error()})
+		return
+	}
+
+	var response agentResponse
+	switch request.Command {
+	case "token":
+		token, err := a.token(request.Client, request.Force)
+		if err != nil {
+			response.Error = err.Error()
+			response.ExitCode = ExitCode(err)
+		} else {
+			response.Token = token
+		}
+	case "unlock":
+		unlockMu.Lock()
+		key := unlockedKey
+		unlockMu.Unlock()
+		if key == nil {
+			response.Error = "the token store is not encrypted"
+		} else {
+			response.Key = base64.StdEncoding.EncodeToString(key)
+		}
+	case "stop":
+		stopAgent()
+	default:
+		response.Error = "unknown command: " + request.Command
+	}
+	json.NewEncoder(conn).Encode(response)
+}
+
+func (a *tokenAgent) entry(clientName string) *agentEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry, ok := a.entries[clientName]
+	if !ok {
+		entry = &agentEntry{}
+		a.entries[clientName] = entry
+	}
+	return entry
+}
+
+// Drop the entry of the client unless it was replaced in the meantime
+func (a *tokenAgent) forget(clientName string, entry *agentEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.entries[clientName] == entry {
+		delete(a.entries, clientName)
+	}
+}
+
+// Reject connections from processes of other users. Platforms without peer credentials rely on the
+// permissions of the socket.
+func checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("not a Unix socket connection")
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var uid int
+	var uidErr error
+	err = rawConn.Control(func(fd uintptr) {
+		uid, uidErr = socketPeerUID(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	if errors.Is(uidErr, errPeerUnknown) {
+		return nil
+	}
+	if uidErr != nil {
+		return fmt.Errorf("reading peer credentials: %w", uidErr)
+	}
+	if uid != os.Getuid() {
+		return fmt.Errorf("connection from user %d refused", uid)
+	}
+	return nil
+}
+
+// Return the in-memory token of the client while it is still the cached one, refreshing it once for all concurrent callers
+func (a *tokenAgent) token(clientName string, forceRefresh bool) (string, error) {
+	if clientName == "" {
+		return "", fmt.Errorf("client name is required")
+	}
+
+	entry := a.entry(clientName)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if !forceRefresh && entry.valid() && entry.current(clientName) {
+		return entry.token, nil
+	}
+
+	token, err := getAccessToken(clientName, forceRefresh)
+	if err != nil {
+		return "", err
+	}
+	entry.token = token
+	entry.expiry, entry.hasExpiry = accessTokenExpiry(clientName, token)
+	// Tokens of unknown expiry are renewed like get-token does, not held forever
+	if !entry.hasExpiry {
+		entry.expiry, entry.hasExpiry = time.Now().Add(tokendokey.DefaultAccessTokenLifetime), true
+	}
+	return token, nil
+}
+
+// Refresh tokens that are about to expire so requests never wait for the token endpoint
+func (a *tokenAgent) refreshLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(agentCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		a.mu.Lock()
+		clientNames := make([]string, 0, len(a.entries))
+		for clientName := range a.entries {
+			clientNames = append(clientNames, clientName)
+		}
+		a.mu.Unlock()
+
+		for _, clientName := range clientNames {
+			entry := a.entry(clientName)
+			entry.mu.Lock()
+			expiry, ok := entry.expiry, entry.hasExpiry
+			current := entry.current(clientName)
+			retryAt := entry.retryAt
+			entry.mu.Unlock()
+			// Clients logged out or changed since are not refreshed, the next request fetches their token again
+			if !current {
+				a.forget(clientName, entry)
+				continue
+			}
+			if ok && time.Until(expiry) < agentRefreshAhead && time.Now().After(retryAt) {
+				a.backgroundRefresh(clientName, entry)
+			}
+		}
+	}
+}
+
+// Refresh the token of a client ahead of its expiry. Clients that need a login or a fixed configuration are
+// dropped, retrying cannot help them; other failures are retried with exponential backoff.
+func (a *tokenAgent) backgroundRefresh(clientName string, entry *agentEntry) {
+	_, err := a.token(clientName, true)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if err == nil {
+		entry.failures, entry.retryAt = 0, time.Time{}
+		return
+	}
+	switch ExitCode(err) {
+	case exitLoginRequired, exitInvalidClient:
+		fmt.Fprintf(os.Stderr, "tokendokey agent: dropping client %s: %v\n", clientName, err)
+		a.forget(clientName, entry)
+		return
+	}
+	entry.failures++
+	retryInterval := agentCheckInterval << min(entry.failures, 10)
+	if retryInterval > agentMaxRetryInterval {
+		retryInterval = agentMaxRetryInterval
+	}
+	entry.retryAt = time.Now().Add(retryInterval)
+	fmt.Fprintf(os.Stderr, "tokendokey agent: refreshing client %s failed, retrying in %s: %v\n", clientName, retryInterval, err)
+}
+
+// Take the store key handed over by startAgentProcess
+func readAgentKey() error {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		return err
+	}
+	unlockMu.Lock()
+	unlockedKey = key
+	unlockMu.Unlock()
+	return nil
+}
+
+// Send a request to the agent listening on socketPath
+func callAgent(socketPath string, request agentRequest) (agentResponse, error) {
+	conn, err := net.DialTimeout("unix", socketPath, agentStartupTimeout)
+	if err != nil {
+		return agentResponse{}, fmt.Errorf("%w: %v", errAgentUnavailable, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentConnTimeout))
+
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		return agentResponse{}, fmt.Errorf("%w: %v", errAgentUnavailable, err)
+	}
+
+	var response agentResponse
+	err = json.NewDecoder(conn).Decode(&response)
+	if err != nil {
+		return agentResponse{}, fmt.Errorf("%w: %v", errAgentUnavailable, err)
+	}
+	if response.Error != "" {
+		exitCode := response.ExitCode
+		if exitCode == 0 {
+			exitCode = exitError
+		}
+		return response, &agentError{message: response.Error, exitCode: exitCode}
+	}
+	return response, nil
+}
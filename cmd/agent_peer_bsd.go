@@ -0,0 +1,14 @@
+//go:build darwin || freebsd
+
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// User ID of the process connected to the agent socket
+func socketPeerUID(fd int) (int, error) {
+	cred, err := unix.GetsockoptXucred(fd, unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	if err != nil {
+		return 0, err
+	}
+	return int(cred.Uid), nil
+}
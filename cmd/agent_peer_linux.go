@@ -0,0 +1,14 @@
+//go:build linux
+
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// User ID of the process connected to the agent socket
+func socketPeerUID(fd int) (int, error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return 0, err
+	}
+	return int(cred.Uid), nil
+}
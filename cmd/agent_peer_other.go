@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package cmd
+
+// Peers cannot be identified, the agent relies on the permissions of its socket alone
+func socketPeerUID(fd int) (int, error) {
+	return 0, errPeerUnknown
+}
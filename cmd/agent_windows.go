@@ -0,0 +1,20 @@
+//go:build windows
+
+package cmd
+
+import (
+	"net"
+	"syscall"
+)
+
+const detachedProcess = 0x00000008
+
+// Start the agent without a console so it survives the terminal it was started from
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: detachedProcess, HideWindow: true}
+}
+
+// Listen on a socket in the folder of the current user, Windows has no umask
+func listenSocket(socketPath string) (net.Listener, error) {
+	return net.Listen("unix", socketPath)
+}
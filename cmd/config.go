@@ -2,79 +2,95 @@ package cmd
 
 import (
 	"archive/zip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+
+	"tokendokey/pkg/tokendokey"
 
 	"github.com/spf13/cobra"
 )
 
 var ExportCmd = &cobra.Command{
-	Use:   "export -c=[client_name]",
+	Use:   "export -c=[client_name] [--plaintext]",
 	Short: "Export the configuration of [client_name] to the current folder.",
 	Long: `Export the configuration of [client_name] to the current folder.
-The configuration will be saved in a file named 'tokendokey.key' in the current directory.
+The configuration will be saved in a file named 'tokendokey.key' in the current directory,
+only readable by the current user. The file holds the client secret and tokens in plaintext,
+exporting from an encrypted or Secret Service store therefore requires --plaintext.
 
 Example:
-  export -c=myclient`,
+  export -c=myclient
+  export -c=myclient --plaintext`,
 	Args: cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		clientName, _ := cmd.Flags().GetString("client")
 		if clientName == "" {
-			fmt.Println("Error: client name is required")
-			return
+			return errors.New("client name is required")
 		}
+		plaintext, _ := cmd.Flags().GetBool("plaintext")
 
-		configDir := filepath.Join(getHomeDir(), ".tokendokey", clientName)
+		store, err := tokendokey.DefaultStore()
+		if err != nil {
+			return err
+		}
+		// Secrets protected by the store would end up in plaintext
+		if _, fileStore := store.(*tokendokey.FileStore); !fileStore {
+			if !plaintext {
+				return errors.New("the token store protects the client secret and tokens, tokendokey.key would hold them in plaintext; pass --plaintext to export anyway")
+			}
+			fmt.Fprintln(os.Stderr, "Warning: tokendokey.key holds the client secret and tokens in plaintext, keep it safe and delete it after importing.")
+		}
+		keys, err := store.Keys(clientName)
+		if err != nil {
+			return err
+		}
 
-		// Create tokendokey.key file
-		zipFile, err := os.Create("tokendokey.key")
+		// Create tokendokey.key file, only readable by the current user like the store
+		zipFile, err := os.OpenFile("tokendokey.key", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
-			fmt.Println("Error creating tokendokey.key file:", err)
-			return
+			return fmt.Errorf("creating tokendokey.key file: %w", err)
 		}
 		defer zipFile.Close()
+		// An existing file keeps its permissions when truncated
+		err = zipFile.Chmod(0600)
+		if err != nil {
+			return fmt.Errorf("restricting tokendokey.key file: %w", err)
+		}
 
 		zipWriter := zip.NewWriter(zipFile)
 		defer zipWriter.Close()
 
-		// Function to add files to the zip
-		addFilesToZip := func(basePath string) error {
-			return filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
+		// Function to add the stored files to the zip, decrypted when the store is encrypted
+		addFilesToZip := func() error {
+			for _, key := range keys {
+				data, err := store.Get(clientName, key)
 				if err != nil {
 					return err
 				}
 
-				if info.IsDir() {
-					return nil
-				}
-
-				relPath := filepath.Base(path)
-				w, err := zipWriter.Create(relPath)
+				w, err := zipWriter.Create(key)
 				if err != nil {
 					return err
 				}
 
-				src, err := os.Open(path)
+				_, err = w.Write(data)
 				if err != nil {
 					return err
 				}
-				defer src.Close()
-
-				_, err = io.Copy(w, src)
-				return err
-			})
+			}
+			return nil
 		}
 
-		// Add config directory files to zip
-		err = addFilesToZip(configDir)
+		// Add config files to zip
+		err = addFilesToZip()
 		if err != nil {
-			fmt.Println("Error adding config directory files to tokendokey.key:", err)
-			return
+			return fmt.Errorf("adding config files to tokendokey.key: %w", err)
 		}
 
 		fmt.Println("Configuration file tokendokey.key exported successfully to the current folder")
+		return nil
 	},
 }
 
@@ -87,70 +103,62 @@ The configuration will be loaded from a file named 'tokendokey.key' in the curre
 Example:
   import -c=myclient`,
 	Args: cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		clientName, _ := cmd.Flags().GetString("client")
 		if clientName == "" {
-			fmt.Println("Error: client name is required")
-			return
+			return errors.New("client name is required")
 		}
 
-		configDir := filepath.Join(getHomeDir(), ".tokendokey", clientName)
-		os.MkdirAll(configDir, os.ModePerm)
+		store, err := tokendokey.DefaultStore()
+		if err != nil {
+			return err
+		}
 
 		// Check if tokendokey.key file exists
 		zipFilePath := "tokendokey.key"
 		if _, err := os.Stat(zipFilePath); os.IsNotExist(err) {
-			fmt.Println("Error: tokendokey.key file does not exist")
-			return
+			return errors.New("tokendokey.key file does not exist")
 		}
 
 		// Unzip the tokendokey.key file
 		zipFile, err := zip.OpenReader(zipFilePath)
 		if err != nil {
-			fmt.Println("Error opening tokendokey.key file:", err)
-			return
+			return fmt.Errorf("opening tokendokey.key file: %w", err)
 		}
 		defer zipFile.Close()
 
 		for _, file := range zipFile.File {
-			dstPath := filepath.Join(configDir, file.Name)
-
-			// Create directories if necessary
+			// Folders are created by the store
 			if file.FileInfo().IsDir() {
-				os.MkdirAll(dstPath, os.ModePerm)
 				continue
 			}
 
-			// Create destination file
-			dstFile, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-			if err != nil {
-				fmt.Println("Error creating destination file:", err)
-				return
-			}
-			defer dstFile.Close()
-
 			// Open source file
 			srcFile, err := file.Open()
 			if err != nil {
-				fmt.Println("Error opening source file:", err)
-				return
+				return fmt.Errorf("opening source file: %w", err)
 			}
 			defer srcFile.Close()
 
-			// Copy file contents
-			_, err = io.Copy(dstFile, srcFile)
+			// Copy file contents to the store
+			data, err := io.ReadAll(srcFile)
+			if err != nil {
+				return fmt.Errorf("reading source file: %w", err)
+			}
+			err = store.Put(clientName, file.Name, data)
 			if err != nil {
-				fmt.Println("Error copying file:", err)
-				return
+				return fmt.Errorf("storing file: %w", err)
 			}
 		}
 
 		fmt.Println("Configuration tokendokey.key imported successfully from the current folder")
+		return nil
 	},
 }
 
 func init() {
 	ExportCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	ExportCmd.Flags().Bool("plaintext", false, "Export from an encrypted or Secret Service store, writing its secrets in plaintext")
 	ExportCmd.MarkFlagRequired("client")
 
 	ImportCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
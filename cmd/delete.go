@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+
+	"tokendokey/pkg/tokendokey"
 
 	"github.com/spf13/cobra"
 )
@@ -12,27 +14,22 @@ import (
 var DeleteCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delete the config folder for a client",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		clientName, _ := cmd.Flags().GetString("clientname")
 		if clientName == "" {
-			fmt.Println("Error: --clientname parameter is required")
-			return
+			return errors.New("--clientname parameter is required")
 		}
 
-		configPath := filepath.Join(getHomeDir(), ".tokendokey", clientName)
-
-		if _, err := os.Stat(configPath); os.IsNotExist(err) {
-			fmt.Println("Error: Config folder does not exist:", configPath)
-			return
+		err := tokendokey.Remove(clientName)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("config folder does not exist: %w", err)
 		}
-
-		err := os.RemoveAll(configPath)
 		if err != nil {
-			fmt.Println("Error: Unable to delete config folder:", err)
-			return
+			return fmt.Errorf("unable to delete config folder: %w", err)
 		}
 
 		fmt.Println("Config folder deleted successfully for client:", clientName)
+		return nil
 	},
 }
 
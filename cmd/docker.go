@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/spf13/cobra"
+)
+
+// Name of the executable docker runs for credsStore/credHelpers "tokendokey"
+const DockerCredentialHelperName = "docker-credential-tokendokey"
+
+const (
+	dockerRegistriesFile = "docker-registries.json"
+	defaultDockerUser    = "oauth2accesstoken"
+	// Docker treats this message as a missing credential instead of a failure
+	dockerCredentialsNotFound = "credentials not found in native keychain"
+)
+
+// Client whose access token is the password of a registry
+type dockerRegistry struct {
+	Client   string `json:"client"`
+	Username string `json:"username,omitempty"`
+}
+
+// Credentials exchanged with docker on stdin/stdout
+type dockerCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+var DockerCredentialCmd = &cobra.Command{
+	Use:   "docker-credential [get|store|erase|list]",
+	Short: "Docker credential helper returning access tokens of the clients mapped to registries.",
+	Long: `Docker credential helper returning the access token of a tokendokey client as registry password.
+Docker runs it as docker-credential-tokendokey, a copy or link of tokendokey with that name, and talks the
+credential helper protocol on stdin/stdout. Registries are mapped to clients with the map subcommand,
+the mapping is kept in ~/.tokendokey/docker-registries.json.`,
+	Example: `  tokendokey docker-credential map registry.example.com -c=myclient
+  tokendokey docker-credential map registry.example.com -c=myclient --username=robot
+  echo registry.example.com | docker-credential-tokendokey get`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "get":
+			err = dockerCredentialGet(os.Stdin)
+		case "store":
+			err = dockerCredentialStore(os.Stdin)
+		case "erase":
+			err = dockerCredentialErase(os.Stdin)
+		case "list":
+			err = dockerCredentialList()
+		default:
+			err = fmt.Errorf("unknown credential action: %s", args[0])
+		}
+		if err != nil {
+			// Docker reads the error message from stdout
+			fmt.Println(err)
+			os.Exit(ExitCode(err))
+		}
+	},
+}
+
+var DockerCredentialMapCmd = &cobra.Command{
+	Use:     "map [registry] -c=[client_name] [--username=[username]]",
+	Short:   "Use the access token of [client_name] as password for [registry].",
+	Example: `  tokendokey docker-credential map registry.example.com -c=myclient`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		if clientName == "" {
+			return errors.New("client name is required")
+		}
+		username, _ := cmd.Flags().GetString("username")
+
+		registries, err := loadDockerRegistries()
+		if err != nil {
+			return fmt.Errorf("loading registry mapping: %w", err)
+		}
+		registries[registryHost(args[0])] = dockerRegistry{Client: clientName, Username: username}
+		err = saveDockerRegistries(registries)
+		if err != nil {
+			return fmt.Errorf("saving registry mapping: %w", err)
+		}
+		fmt.Println("Registry", registryHost(args[0]), "mapped to client", clientName)
+		return nil
+	},
+}
+
+func init() {
+	DockerCredentialMapCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	DockerCredentialMapCmd.Flags().String("username", "", "Username sent with the token (oauth2accesstoken when empty)")
+	DockerCredentialMapCmd.MarkFlagRequired("client")
+	DockerCredentialCmd.AddCommand(DockerCredentialMapCmd)
+}
+
+func dockerRegistriesPath() (string, error) {
+	dir, err := tokendokey.DefaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, dockerRegistriesFile), nil
+}
+
+func loadDockerRegistries() (map[string]dockerRegistry, error) {
+	registries := map[string]dockerRegistry{}
+	path, err := dockerRegistriesPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return registries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &registries)
+	if err != nil {
+		return nil, err
+	}
+	return registries, nil
+}
+
+func saveDockerRegistries(registries map[string]dockerRegistry) error {
+	path, err := dockerRegistriesPath()
+	if err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(registries, "", "  ")
+	return tokendokey.WriteFile(path, data)
+}
+
+// Host of a registry server URL, docker sends both bare hosts and URLs
+func registryHost(serverURL string) string {
+	serverURL = strings.TrimSpace(serverURL)
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "https://" + serverURL
+	}
+	parsed, err := url.Parse(serverURL)
+	if err != nil || parsed.Host == "" {
+		return serverURL
+	}
+	return parsed.Host
+}
+
+func readServerURL(stdin io.Reader) (string, error) {
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return "", err
+	}
+	serverURL := strings.TrimSpace(string(data))
+	if serverURL == "" {
+		return "", fmt.Errorf("no credentials server URL")
+	}
+	return serverURL, nil
+}
+
+func dockerCredentialGet(stdin io.Reader) error {
+	serverURL, err := readServerURL(stdin)
+	if err != nil {
+		return err
+	}
+	registries, err := loadDockerRegistries()
+	if err != nil {
+		return err
+	}
+	registry, ok := registries[registryHost(serverURL)]
+	if !ok {
+		return errors.New(dockerCredentialsNotFound)
+	}
+
+	accessToken, err := accessTokenFor(registry.Client, false)
+	if err != nil {
+		return fmt.Errorf("getting access token of %s: %w", registry.Client, err)
+	}
+
+	username := registry.Username
+	if username == "" {
+		username = defaultDockerUser
+	}
+	return json.NewEncoder(os.Stdout).Encode(dockerCredentials{ServerURL: serverURL, Username: username, Secret: accessToken})
+}
+
+// Tokens come from tokendokey, so docker login only succeeds for mapped registries
+func dockerCredentialStore(stdin io.Reader) error {
+	var credentials dockerCredentials
+	err := json.NewDecoder(stdin).Decode(&credentials)
+	if err != nil {
+		return err
+	}
+	registries, err := loadDockerRegistries()
+	if err != nil {
+		return err
+	}
+	if _, ok := registries[registryHost(credentials.ServerURL)]; !ok {
+		return fmt.Errorf("registry %s is not mapped to a tokendokey client, run: tokendokey docker-credential map %s -c=[client_name]", registryHost(credentials.ServerURL), registryHost(credentials.ServerURL))
+	}
+	return nil
+}
+
+// docker logout removes the registry mapping
+func dockerCredentialErase(stdin io.Reader) error {
+	serverURL, err := readServerURL(stdin)
+	if err != nil {
+		return err
+	}
+	registries, err := loadDockerRegistries()
+	if err != nil {
+		return err
+	}
+	if _, ok := registries[registryHost(serverURL)]; !ok {
+		return errors.New(dockerCredentialsNotFound)
+	}
+	delete(registries, registryHost(serverURL))
+	return saveDockerRegistries(registries)
+}
+
+func dockerCredentialList() error {
+	registries, err := loadDockerRegistries()
+	if err != nil {
+		return err
+	}
+	list := map[string]string{}
+	for host, registry := range registries {
+		username := registry.Username
+		if username == "" {
+			username = defaultDockerUser
+		}
+		list[host] = username
+	}
+	return json.NewEncoder(os.Stdout).Encode(list)
+}
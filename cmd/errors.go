@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"errors"
+	"net"
+	"net/url"
+
+	"tokendokey/pkg/tokendokey"
+)
+
+// Exit codes of failed commands, documented in the README
+const (
+	exitError = 1
+	// No usable refresh token, the user has to login again
+	exitLoginRequired = 2
+	// The user denied the device authorization request
+	exitAccessDenied = 3
+	// The device code expired before the user finished the authorization
+	exitExpiredToken = 4
+	// The server rejected the client, its configuration needs fixing
+	exitInvalidClient = 5
+	// The server could not be reached
+	exitNetwork = 6
+	// The server failed, retrying later may succeed
+	exitServer = 7
+	// The token failed signature or claims verification
+	exitInvalidToken = 8
+)
+
+// Errors carrying their own exit code, e.g. relayed from the agent
+type exitCoder interface {
+	ExitCode() int
+}
+
+// Error of a request served by the agent, keeping the exit code of the original error
+type agentError struct {
+	message  string
+	exitCode int
+}
+
+func (e *agentError) Error() string {
+	return e.message
+}
+
+func (e *agentError) ExitCode() int {
+	return e.exitCode
+}
+
+// Exit code of the category of err, see the Exit Codes section of the README
+func ExitCode(err error) int {
+	var coder exitCoder
+	if errors.As(err, &coder) {
+		return coder.ExitCode()
+	}
+
+	switch {
+	case errors.Is(err, tokendokey.ErrLoginRequired), errors.Is(err, tokendokey.ErrRefreshTokenReused):
+		return exitLoginRequired
+	case errors.Is(err, tokendokey.ErrAccessDenied):
+		return exitAccessDenied
+	case errors.Is(err, tokendokey.ErrExpiredToken):
+		return exitExpiredToken
+	case errors.Is(err, tokendokey.ErrInvalidToken):
+		return exitInvalidToken
+	}
+
+	var oauthErr *tokendokey.OAuthError
+	if errors.As(err, &oauthErr) {
+		switch {
+		case oauthErr.ClientError():
+			return exitInvalidClient
+		case oauthErr.ServerError():
+			return exitServer
+		}
+		return exitError
+	}
+
+	// HTTP clients fail with *url.Error, connections with *net.OpError. net.Error
+	// would also match plain system errors such as a missing file.
+	var urlErr *url.Error
+	var opErr *net.OpError
+	if errors.As(err, &urlErr) || errors.As(err, &opErr) {
+		return exitNetwork
+	}
+	return exitError
+}
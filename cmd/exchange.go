@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/spf13/cobra"
+)
+
+var ExchangeCmd = &cobra.Command{
+	Use:   "exchange -c=[client_name] --audience=[audience] [--scope=[scope]] [--actor=[actor_client]]",
+	Short: "Exchange the access token of [client_name] for a token for another audience (RFC 8693).",
+	Long: `Exchange the cached access token of the specified client for a token issued to another audience,
+using OAuth 2.0 Token Exchange (RFC 8693). The access token of the --actor client is sent as actor_token when given.
+The exchanged token is cached per audience in the exchange folder of the source client.`,
+	Example: `  tokendokey exchange -c=myclient --audience=billing-api
+  tokendokey exchange -c=myclient --audience=billing-api --scope="billing.read" --actor=mysvc
+  tokendokey exchange --client=myclient --audience=billing-api --force`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		if clientName == "" {
+			return errors.New("client name is required")
+		}
+		audience, _ := cmd.Flags().GetString("audience")
+		if audience == "" {
+			return errors.New("audience is required")
+		}
+		scope, _ := cmd.Flags().GetString("scope")
+		actorClient, _ := cmd.Flags().GetString("actor")
+		forceRefresh, _ := cmd.Flags().GetBool("force")
+
+		client, err := tokendokey.Load(clientName)
+		if err != nil {
+			return err
+		}
+
+		options := tokendokey.ExchangeOptions{Audience: audience, Scope: scope, ActorClient: actorClient, Force: forceRefresh}
+		accessToken, err := client.Exchange(context.Background(), options)
+		if err != nil {
+			return fmt.Errorf("exchanging token: %w", err)
+		}
+		fmt.Println(accessToken)
+		return nil
+	},
+}
+
+func init() {
+	ExchangeCmd.Flags().StringP("client", "c", "", "Client name whose access token is exchanged")
+	ExchangeCmd.Flags().String("audience", "", "Audience of the requested token")
+	ExchangeCmd.Flags().String("scope", "", "Scope of the requested token")
+	ExchangeCmd.Flags().String("actor", "", "Client name whose access token is sent as actor_token")
+	ExchangeCmd.Flags().BoolP("force", "f", false, "Force a new exchange even if the cached token is still valid.")
+	ExchangeCmd.MarkFlagRequired("client")
+	ExchangeCmd.MarkFlagRequired("audience")
+}
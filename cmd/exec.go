@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultTokenEnv = "ACCESS_TOKEN"
+	// Rotate tokens this long before they expire, tokens without exp are checked at this interval
+	execRefreshAhead = 1 * time.Minute
+	execMinSleep     = 5 * time.Second
+	// Failed refreshes are retried after execMinSleep, doubling up to this interval
+	execMaxRetryInterval = 5 * time.Minute
+)
+
+var ExecCmd = &cobra.Command{
+	Use:   "exec -c=[client_name] [--env=[NAME]] [--token-file] [--signal=[SIGNAL]] -- command [args...]",
+	Short: "Run a command with a live access token of [client_name] in its environment.",
+	Long: `Run a command with the access token of the specified client in the environment variable NAME
+(ACCESS_TOKEN by default) and its expiry in NAME_EXPIRES_AT. With --token-file the token is also written
+to a temporary file named by NAME_FILE, which is kept up to date while the command runs: tokendokey
+refreshes the token shortly before it expires and optionally sends --signal to the command on rotation.
+The exit code of the command is returned.`,
+	Example: `  tokendokey exec -c=myclient -- curl -H "Authorization: Bearer $ACCESS_TOKEN" https://api.example.com
+  tokendokey exec -c=myclient --env=API_TOKEN -- ./batch-job
+  tokendokey exec -c=myclient --token-file --signal=HUP -- ./long-running-server`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		if clientName == "" {
+			return errors.New("client name is required")
+		}
+		envName, _ := cmd.Flags().GetString("env")
+		useTokenFile, _ := cmd.Flags().GetBool("token-file")
+		signalName, _ := cmd.Flags().GetString("signal")
+
+		var rotationSignal os.Signal
+		if signalName != "" {
+			var err error
+			rotationSignal, err = parseSignal(signalName)
+			if err != nil {
+				return err
+			}
+		}
+
+		accessToken, err := accessTokenFor(clientName, false)
+		if err != nil {
+			return fmt.Errorf("getting access token: %w", err)
+		}
+
+		env := append(os.Environ(), envName+"="+accessToken)
+		if expiry, ok := accessTokenExpiry(clientName, accessToken); ok {
+			env = append(env, envName+"_EXPIRES_AT="+expiry.UTC().Format(time.RFC3339))
+		}
+
+		tokenFile := ""
+		if useTokenFile {
+			tokenDir, err := os.MkdirTemp("", "tokendokey-exec-")
+			if err != nil {
+				return fmt.Errorf("creating token file: %w", err)
+			}
+			defer os.RemoveAll(tokenDir)
+			tokenFile = filepath.Join(tokenDir, "token")
+			err = writeTokenFile(tokenFile, accessToken)
+			if err != nil {
+				return fmt.Errorf("creating token file: %w", err)
+			}
+			env = append(env, envName+"_FILE="+tokenFile)
+		}
+
+		child := exec.Command(args[0], args[1:]...)
+		child.Env = env
+		child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err = child.Start()
+		if err != nil {
+			return fmt.Errorf("starting command: %w", err)
+		}
+
+		// Pass termination requests on instead of dying before the child
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case sig := <-signals:
+					child.Process.Signal(sig)
+				case <-done:
+					return
+				}
+			}
+		}()
+		go rotateExecToken(clientName, accessToken, tokenFile, child.Process, rotationSignal, done)
+
+		err = child.Wait()
+		close(done)
+		signal.Stop(signals)
+		if useTokenFile {
+			// os.Exit skips the deferred cleanup
+			os.RemoveAll(filepath.Dir(tokenFile))
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(childExitCode(exitErr))
+		}
+		if err != nil {
+			return fmt.Errorf("running command: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	ExecCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	ExecCmd.Flags().String("env", defaultTokenEnv, "Environment variable receiving the access token")
+	ExecCmd.Flags().Bool("token-file", false, "Also keep the token up to date in a temporary file named by NAME_FILE")
+	ExecCmd.Flags().String("signal", "", "Signal sent to the command when the token is rotated, e.g. HUP")
+	ExecCmd.MarkFlagRequired("client")
+	// Everything after the command belongs to the command
+	ExecCmd.Flags().SetInterspersed(false)
+}
+
+// Refresh the token before it expires until done, updating the token file and signaling the child.
+// Failed refreshes are retried with exponential backoff, rotation stops when only a login helps.
+func rotateExecToken(clientName, accessToken, tokenFile string, process *os.Process, rotationSignal os.Signal, done <-chan struct{}) {
+	failures := 0
+	for {
+		wait := execRefreshAhead
+		expiry, hasExpiry := accessTokenExpiry(clientName, accessToken)
+		if hasExpiry {
+			wait = time.Until(expiry) - execRefreshAhead
+		}
+		if wait < execMinSleep {
+			wait = execMinSleep
+		}
+		if failures > 0 {
+			wait = execMinSleep << min(failures-1, 10)
+			if wait > execMaxRetryInterval {
+				wait = execMaxRetryInterval
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(wait):
+		}
+
+		forceRefresh := hasExpiry && time.Until(expiry) <= execRefreshAhead
+		newToken, err := accessTokenFor(clientName, forceRefresh)
+		if err != nil {
+			switch ExitCode(err) {
+			case exitLoginRequired, exitInvalidClient:
+				fmt.Fprintf(os.Stderr, "tokendokey: stopped rotating the access token of client %s: %v\n", clientName, err)
+				return
+			}
+			failures++
+			fmt.Fprintln(os.Stderr, "tokendokey: error refreshing access token:", err)
+			continue
+		}
+		failures = 0
+		if newToken == accessToken {
+			continue
+		}
+		accessToken = newToken
+
+		if tokenFile != "" {
+			err = writeTokenFile(tokenFile, accessToken)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "tokendokey: error updating token file:", err)
+				continue
+			}
+		}
+		if rotationSignal != nil {
+			process.Signal(rotationSignal)
+		}
+	}
+}
+
+// Replace the token file in one step so the child never reads a partial token
+func writeTokenFile(tokenFile, accessToken string) error {
+	tmpFile := tokenFile + ".tmp"
+	err := os.WriteFile(tmpFile, []byte(accessToken), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, tokenFile)
+}
+
+// Exit code of the child, 128+signal when it was killed like shells report it
+func childExitCode(exitErr *exec.ExitError) int {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	if exitErr.ExitCode() < 0 {
+		return 1
+	}
+	return exitErr.ExitCode()
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+)
+
+// Windows processes cannot be sent signals other than kill
+func parseSignal(name string) (os.Signal, error) {
+	return nil, fmt.Errorf("signals are not supported on Windows: %s", name)
+}
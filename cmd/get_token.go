@@ -1,17 +1,14 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
+	"tokendokey/pkg/tokendokey"
+
 	"github.com/spf13/cobra"
 )
 
@@ -19,77 +16,34 @@ var GetTokenCmd = &cobra.Command{
 	Use:   "get-token -c=[client_name] -f",
 	Short: "Get a new access token from [client_name]. If [--force] is specified, will force a refresh.",
 	Long: `Get a new access token from the specified client.
-If the forcerefresh parameter is provided, a refresh will be forced even if the current access token is still valid.`,
+When TOKENDOKEY_AGENT_SOCK is set, the token is requested from the running agent.
+If the forcerefresh parameter is provided, a refresh will be forced even if the current access token is still valid.
+Clients initialized with --grant-type=client_credentials fetch their token with client_id/client_secret
+and never need login, jwt_bearer clients exchange the JWT in their assertion file instead.
+The cached access token is used until shortly before it expires. Opaque tokens whose response had no
+expires_in are assumed to expire 5 minutes after they were issued, and are renewed then.`,
 	Example: `  tokendokey get-token -c=myclient
 	tokendokey get-token -c=myclient -f
   tokendokey get-token --client=myclient --force`,
 	Args: cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		clientName, _ := cmd.Flags().GetString("client")
 		if clientName == "" {
-			fmt.Println("Error: client name is required")
-			return
+			return errors.New("client name is required")
 		}
 
 		forceRefresh, _ := cmd.Flags().GetBool("force")
 
-		configDir := filepath.Join(getHomeDir(), ".tokendokey", clientName)
-		configFilePath := filepath.Join(configDir, "config.json")
-		refreshTokenPath := filepath.Join(configDir, "refresh_token.txt")
-		accessTokenPath := filepath.Join(configDir, "access_token.txt")
-
-		configData, _ := os.ReadFile(configFilePath)
-		var config Config
-		json.Unmarshal(configData, &config)
-
-		accessToken, _ := os.ReadFile(accessTokenPath)
-		if !forceRefresh && len(accessToken) > 0 && isTokenValid(string(accessToken), "access") {
-			fmt.Println(string(accessToken))
-			return
-		}
-
-		refreshToken, _ := os.ReadFile(refreshTokenPath)
-		if len(refreshToken) == 0 || !isTokenValid(string(refreshToken), "refresh") {
-			fmt.Println("Refresh token is invalid. Please get new Refresh token.")
-			return
-		}
-
-		form := url.Values{
-			"client_id":     {config.ClientID},
-			"grant_type":    {"refresh_token"},
-			"refresh_token": {string(refreshToken)},
-		}
-
-		if config.ClientSecret != "" {
-			form.Add("client_secret", config.ClientSecret)
-		}
-
-		req, _ := http.NewRequest("POST", config.TokenIssueURL, strings.NewReader(form.Encode()))
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		accessToken, err := accessTokenFor(clientName, forceRefresh)
 		if err != nil {
-			fmt.Println("Error getting new access token:", err)
-			return
-		}
-		if resp.StatusCode != http.StatusOK {
-			fmt.Println("Error getting new access token:", resp.StatusCode)
-			return
+			// The login required errors explain themselves, including whether the refresh token was reused
+			if errors.Is(err, tokendokey.ErrLoginRequired) || errors.Is(err, tokendokey.ErrRefreshTokenReused) {
+				return err
+			}
+			return fmt.Errorf("getting new access token: %w", err)
 		}
-		defer resp.Body.Close()
-
-		body, _ := io.ReadAll(resp.Body)
-		var tokenResponse map[string]string
-		json.Unmarshal(body, &tokenResponse)
-
-		newAccessToken := tokenResponse["access_token"]
-		newrefreshToken := tokenResponse["refresh_token"]
-
-		os.WriteFile(accessTokenPath, []byte(newAccessToken), 0644)
-		os.WriteFile(refreshTokenPath, []byte(newrefreshToken), 0644)
-
-		fmt.Println(newAccessToken)
+		fmt.Println(accessToken)
+		return nil
 	},
 }
 
@@ -99,28 +53,38 @@ func init() {
 	GetTokenCmd.MarkFlagRequired("client")
 }
 
-func isTokenValid(tokenString string, tokenType string) bool {
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
-	if err != nil {
-		return false
+// Return a valid access token for the client, preferring the agent when one is running
+func accessTokenFor(clientName string, forceRefresh bool) (string, error) {
+	if socketPath := os.Getenv(agentSockEnv); socketPath != "" {
+		response, err := callAgent(socketPath, agentRequest{Command: "token", Client: clientName, Force: forceRefresh})
+		if err == nil {
+			return response.Token, nil
+		}
+		if !errors.Is(err, errAgentUnavailable) {
+			return "", err
+		}
 	}
+	return getAccessToken(clientName, forceRefresh)
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if exp, ok := claims["exp"].(float64); ok {
-			expirationTime := time.Unix(int64(exp), 0)
-
-			var validityDuration time.Duration
-			switch tokenType {
-			case "access":
-				validityDuration = 30 * time.Second
-			case "refresh":
-				validityDuration = 1 * time.Minute
-			default:
-				validityDuration = 30 * time.Second
-			}
+// Return a valid access token for the client, from cache or by running the client's grant
+func getAccessToken(clientName string, forceRefresh bool) (string, error) {
+	client, err := tokendokey.Load(clientName)
+	if err != nil {
+		return "", err
+	}
+	if forceRefresh {
+		return client.Refresh(context.Background())
+	}
+	return client.Token(context.Background())
+}
 
-			return time.Now().Before(expirationTime.Add(-validityDuration))
-		}
+// Expiry of an access token of the client, when get-token renews it if the token is the cached one,
+// else from its exp claim
+func accessTokenExpiry(clientName, accessToken string) (time.Time, bool) {
+	client, err := tokendokey.Load(clientName)
+	if err == nil && client.AccessToken() == accessToken {
+		return client.AccessTokenRenewalExpiry()
 	}
-	return false
+	return tokendokey.TokenExpiry(accessToken)
 }
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	gitHostsFile   = "git-hosts.json"
+	defaultGitUser = "oauth2"
+)
+
+// Client whose access token is the password for a git host, optionally limited to a path prefix
+type gitHost struct {
+	Host     string `json:"host"`
+	Path     string `json:"path,omitempty"`
+	Client   string `json:"client"`
+	Username string `json:"username,omitempty"`
+}
+
+var GitCredentialCmd = &cobra.Command{
+	Use:   "git-credential [-c=[client_name]] [get|store|erase]",
+	Short: "Git credential helper answering with the access token of the client mapped to the host.",
+	Long: `Git credential helper answering with a username and the access token of a tokendokey client as password.
+The client is given with -c or resolved from the host and path mapping kept in ~/.tokendokey/git-hosts.json,
+see the map subcommand. Git sends the path only when credential.useHttpPath is enabled.
+erase, run by git when the server rejects the credential, forces a token refresh, store does nothing.`,
+	Example: `  git config --global credential.https://git.example.com.helper "!tokendokey git-credential -c=myclient"
+  tokendokey git-credential map git.example.com -c=myclient
+  tokendokey git-credential map git.example.com/team-a -c=teamclient --username=bot
+  git config --global credential.helper "!tokendokey git-credential"`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		clientName, _ := cmd.Flags().GetString("client")
+
+		// Unknown actions must be ignored, see gitcredentials(7)
+		action := args[0]
+		if action != "get" && action != "store" && action != "erase" {
+			return
+		}
+
+		attributes, err := readGitCredentialAttributes(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading credential request:", err)
+			os.Exit(1)
+		}
+		if action == "store" {
+			return
+		}
+		if attributes["protocol"] != "https" && attributes["protocol"] != "http" {
+			return
+		}
+
+		username := ""
+		if clientName == "" {
+			host, err := resolveGitHost(attributes["host"], attributes["path"])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Error loading git host mapping:", err)
+				os.Exit(1)
+			}
+			// Not ours, git moves on to the next helper or prompts
+			if host == nil {
+				return
+			}
+			clientName, username = host.Client, host.Username
+		}
+
+		if action == "erase" {
+			accessTokenFor(clientName, true)
+			return
+		}
+
+		accessToken, err := accessTokenFor(clientName, false)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error getting access token of", clientName+":", err)
+			os.Exit(ExitCode(err))
+		}
+		if username == "" {
+			username = attributes["username"]
+		}
+		if username == "" {
+			username = defaultGitUser
+		}
+		fmt.Printf("username=%s\npassword=%s\n", username, accessToken)
+	},
+}
+
+var GitCredentialMapCmd = &cobra.Command{
+	Use:   "map [host[/path]] -c=[client_name] [--username=[username]]",
+	Short: "Use the access token of [client_name] as git password for [host], or only below [path].",
+	Example: `  tokendokey git-credential map git.example.com -c=myclient
+  tokendokey git-credential map git.example.com/team-a -c=teamclient`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		if clientName == "" {
+			return errors.New("client name is required")
+		}
+		username, _ := cmd.Flags().GetString("username")
+
+		target := strings.TrimPrefix(strings.TrimPrefix(args[0], "https://"), "http://")
+		hostName, path, _ := strings.Cut(strings.TrimSuffix(target, "/"), "/")
+
+		hosts, err := loadGitHosts()
+		if err != nil {
+			return fmt.Errorf("loading git host mapping: %w", err)
+		}
+		mapped := gitHost{Host: hostName, Path: path, Client: clientName, Username: username}
+		replaced := false
+		for i, host := range hosts {
+			if host.Host == hostName && host.Path == path {
+				hosts[i] = mapped
+				replaced = true
+			}
+		}
+		if !replaced {
+			hosts = append(hosts, mapped)
+		}
+
+		err = saveGitHosts(hosts)
+		if err != nil {
+			return fmt.Errorf("saving git host mapping: %w", err)
+		}
+		fmt.Println("Git host", target, "mapped to client", clientName)
+		return nil
+	},
+}
+
+func init() {
+	GitCredentialCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration (resolved from the mapping when empty)")
+	GitCredentialMapCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	GitCredentialMapCmd.Flags().String("username", "", "Username sent with the token (the username git asks for, or oauth2)")
+	GitCredentialMapCmd.MarkFlagRequired("client")
+	GitCredentialCmd.AddCommand(GitCredentialMapCmd)
+}
+
+// Read the key=value lines git sends, up to a blank line or the end of input
+func readGitCredentialAttributes(stdin io.Reader) (map[string]string, error) {
+	attributes := map[string]string{}
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if ok {
+			attributes[key] = value
+		}
+	}
+	return attributes, scanner.Err()
+}
+
+func gitHostsPath() (string, error) {
+	dir, err := tokendokey.DefaultDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, gitHostsFile), nil
+}
+
+func loadGitHosts() ([]gitHost, error) {
+	path, err := gitHostsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hosts []gitHost
+	err = json.Unmarshal(data, &hosts)
+	return hosts, err
+}
+
+func saveGitHosts(hosts []gitHost) error {
+	path, err := gitHostsPath()
+	if err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(hosts, "", "  ")
+	return tokendokey.WriteFile(path, data)
+}
+
+// The mapping of the host with the longest path prefix matching path, nil when none
+func resolveGitHost(hostName, path string) (*gitHost, error) {
+	hosts, err := loadGitHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	path = strings.Trim(path, "/")
+	var best *gitHost
+	for i, host := range hosts {
+		if !strings.EqualFold(host.Host, hostName) {
+			continue
+		}
+		if host.Path != "" && path != host.Path && !strings.HasPrefix(path, host.Path+"/") {
+			continue
+		}
+		if best == nil || len(host.Path) > len(best.Path) {
+			best = &hosts[i]
+		}
+	}
+	return best, nil
+}
@@ -2,45 +2,80 @@ package cmd
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"tokendokey/pkg/tokendokey"
+
 	"github.com/spf13/cobra"
 )
 
-type Config struct {
-	ClientID      string `json:"client_id"`
-	ClientSecret  string `json:"client_secret"`
-	TokenIssueURL string `json:"token_issue_url"`
-	DeviceCodeURL string `json:"device_authorization_endpoint"`
-}
-
 var InitCmd = &cobra.Command{
 	Use:   "init -c [client_name]",
 	Short: "Initialize a new OAuth client configuration",
 	Long: `Initialize a new OAuth client configuration with the specified client name.
 This command sets up the necessary configuration files for OAuth/OIDC authentication.`,
 	Example: `  tokendokey init -c=myclient
-  tokendokey init --client=myclient`,
+  tokendokey init --client=myclient
+  tokendokey init -c=mysvc --grant-type=client_credentials --scope="api.read api.write"
+  tokendokey init -c=billing --grant-type=token_exchange --subject-client=myclient --audience=billing-api
+  tokendokey init -c=ci --grant-type=jwt_bearer --assertion-file=/var/run/secrets/tokens/oidc-token
+  tokendokey init -c=myclient --auth-method=private_key_jwt --private-key=path/to/client.key --key-id=key1`,
 	Args: cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		clientName, _ := cmd.Flags().GetString("client")
 		if clientName == "" {
-			fmt.Println("Error: client name is required")
-			return
+			return errors.New("client name is required")
 		}
 
-		configDir := filepath.Join(getHomeDir(), ".tokendokey", clientName)
-		os.MkdirAll(configDir, os.ModePerm)
+		grantType, _ := cmd.Flags().GetString("grant-type")
+		if !tokendokey.IsSupportedGrantType(grantType) {
+			return fmt.Errorf("unsupported grant type: %s", grantType)
+		}
+		scope, _ := cmd.Flags().GetString("scope")
+		audience, _ := cmd.Flags().GetString("audience")
+		subjectClient, _ := cmd.Flags().GetString("subject-client")
+		actorClient, _ := cmd.Flags().GetString("actor-client")
+		if grantType == tokendokey.GrantTokenExchange && subjectClient == "" {
+			return errors.New("token_exchange clients need a --subject-client")
+		}
+		if grantType == tokendokey.GrantTokenExchange {
+			store, err := tokendokey.DefaultStore()
+			if err != nil {
+				return err
+			}
+			exchangeConfig := tokendokey.Config{GrantType: grantType, SubjectClient: subjectClient, ActorClient: actorClient}
+			err = tokendokey.ValidateExchange(store, clientName, exchangeConfig)
+			if err != nil {
+				return err
+			}
+		}
+		assertionFile, _ := cmd.Flags().GetString("assertion-file")
+		if grantType == tokendokey.GrantJWTBearer && assertionFile == "" {
+			return errors.New("jwt_bearer clients need --assertion-file")
+		}
+		if assertionFile != "" {
+			assertionFile, _ = filepath.Abs(assertionFile)
+		}
 
-		configFilePath := filepath.Join(configDir, "config.json")
-		refreshTokenPath := filepath.Join(configDir, "refresh_token.txt")
-		accessTokenPath := filepath.Join(configDir, "access_token.txt")
+		authMethod, _ := cmd.Flags().GetString("auth-method")
+		if !tokendokey.IsSupportedAuthMethod(authMethod) {
+			return fmt.Errorf("unsupported token endpoint auth method: %s", authMethod)
+		}
+		privateKeyPath, _ := cmd.Flags().GetString("private-key")
+		if authMethod == tokendokey.AuthPrivateKeyJWT && privateKeyPath == "" {
+			return errors.New("private_key_jwt needs --private-key")
+		}
+		if privateKeyPath != "" {
+			// Stored absolute so commands work from any folder
+			privateKeyPath, _ = filepath.Abs(privateKeyPath)
+		}
+		keyID, _ := cmd.Flags().GetString("key-id")
+		verifyTokens, _ := cmd.Flags().GetBool("verify")
 
 		reader := bufio.NewReader(os.Stdin)
 
@@ -57,57 +92,85 @@ This command sets up the necessary configuration files for OAuth/OIDC authentica
 		discoveryURL = strings.TrimSpace(discoveryURL)
 
 		// Fetch OAuth/OIDC discovery document
-		resp, err := http.Get(discoveryURL)
+		discovery, err := tokendokey.Discover(context.Background(), discoveryURL)
 		if err != nil {
-			fmt.Println("Error fetching discovery document:", err)
-			return
+			return fmt.Errorf("fetching discovery document: %w", err)
 		}
-		defer resp.Body.Close()
-
-		body, _ := io.ReadAll(resp.Body)
-		var discoveryDoc map[string]interface{}
-		json.Unmarshal(body, &discoveryDoc)
 
-		tokenIssueURL, ok := discoveryDoc["token_endpoint"].(string)
-		if !ok {
+		tokenIssueURL := discovery.TokenEndpoint
+		if tokenIssueURL == "" {
 			fmt.Print("token_endpoint not found in discovery document. Please enter the token endpoint manually: ")
 			tokenIssueURL, _ = reader.ReadString('\n')
 			tokenIssueURL = strings.TrimSpace(tokenIssueURL)
 		}
 
-		deviceAuthURL, ok := discoveryDoc["device_authorization_endpoint"].(string)
-		if !ok {
+		deviceAuthURL := discovery.DeviceAuthorizationEndpoint
+		if deviceAuthURL == "" && grantType == tokendokey.GrantDeviceCode {
 			fmt.Print("device_authorization_endpoint not found in discovery document. Please enter the device authorization endpoint manually: ")
 			deviceAuthURL, _ = reader.ReadString('\n')
 			deviceAuthURL = strings.TrimSpace(deviceAuthURL)
 		}
 
-		config := Config{
-			ClientID:      clientID,
-			ClientSecret:  clientSecret,
-			TokenIssueURL: tokenIssueURL,
-			DeviceCodeURL: deviceAuthURL,
+		config := tokendokey.Config{
+			ClientID:         clientID,
+			ClientSecret:     clientSecret,
+			TokenIssueURL:    tokenIssueURL,
+			DeviceCodeURL:    deviceAuthURL,
+			AuthorizationURL: discovery.AuthorizationEndpoint,
+			Issuer:           discovery.Issuer,
+			JWKSURI:          discovery.JWKSURI,
+			RevocationURL:    discovery.RevocationEndpoint,
+			EndSessionURL:    discovery.EndSessionEndpoint,
+			IntrospectionURL: discovery.IntrospectionEndpoint,
+			UserInfoURL:      discovery.UserInfoEndpoint,
+			Scope:            scope,
+			Audience:         audience,
+			SubjectClient:    subjectClient,
+			ActorClient:      actorClient,
+			AssertionFile:    assertionFile,
+
+			TokenEndpointAuthMethod: authMethod,
+			PrivateKeyPath:          privateKeyPath,
+			KeyID:                   keyID,
+			VerifyTokens:            verifyTokens,
+		}
+		if verifyTokens && (config.Issuer == "" || config.JWKSURI == "") {
+			return errors.New("the discovery document has no issuer or jwks_uri, tokens cannot be verified")
+		}
+		if grantType != tokendokey.GrantDeviceCode {
+			config.GrantType = grantType
 		}
 
-		configData, _ := json.MarshalIndent(config, "", "  ")
-		os.WriteFile(configFilePath, configData, 0644)
-		os.WriteFile(refreshTokenPath, []byte{}, 0644)
-		os.WriteFile(accessTokenPath, []byte{}, 0644)
+		client, err := tokendokey.Create(clientName, config)
+		if err != nil {
+			return fmt.Errorf("saving configuration: %w", err)
+		}
+		if config.JWKSURI != "" {
+			_, err = client.UpdateJWKS(context.Background())
+			if err != nil && verifyTokens {
+				return fmt.Errorf("fetching key set: %w", err)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Warning: fetching key set failed, it is fetched again when tokens are verified:", err)
+			}
+		}
 
 		fmt.Println("Configuration initialized successfully.")
+		return nil
 	},
 }
 
 func init() {
 	InitCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	InitCmd.Flags().String("grant-type", tokendokey.GrantDeviceCode, "Grant type of the client: device_code, client_credentials, token_exchange or jwt_bearer")
+	InitCmd.Flags().String("scope", "", "Scope requested by non-interactive grants")
+	InitCmd.Flags().String("audience", "", "Audience requested by non-interactive grants")
+	InitCmd.Flags().String("subject-client", "", "Client whose access token is exchanged (token_exchange)")
+	InitCmd.Flags().String("actor-client", "", "Client whose access token is sent as actor token (token_exchange)")
+	InitCmd.Flags().String("assertion-file", "", "Path to the JWT used as authorization grant (jwt_bearer)")
+	InitCmd.Flags().String("auth-method", "", "Token endpoint auth method: client_secret_post, client_secret_basic, client_secret_jwt or private_key_jwt")
+	InitCmd.Flags().String("private-key", "", "Path to the PEM private key signing assertions (private_key_jwt)")
+	InitCmd.Flags().String("key-id", "", "Key ID sent in the kid header of client assertions")
+	InitCmd.Flags().Bool("verify", false, "Verify signature and claims of access tokens against the issuer's key set before using them")
 	InitCmd.MarkFlagRequired("client")
 }
-
-func getHomeDir() string {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Println("Error getting home directory:", err)
-		os.Exit(1)
-	}
-	return home
-}
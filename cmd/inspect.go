@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/cobra"
+)
+
+// Decoded token and the reasons it would be rejected, printed by inspect
+type tokenInspection struct {
+	Header map[string]interface{} `json:"header,omitempty"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+	// Why the token could not be decoded, e.g. because it is opaque
+	DecodeError string     `json:"decode_error,omitempty"`
+	Issuer      string     `json:"issuer,omitempty"`
+	Subject     string     `json:"subject,omitempty"`
+	Audience    []string   `json:"audience,omitempty"`
+	Scopes      []string   `json:"scopes,omitempty"`
+	IssuedAt    *time.Time `json:"issued_at,omitempty"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// Seconds until ExpiresAt, negative once expired
+	ExpiresIn *int64   `json:"expires_in,omitempty"`
+	Valid     bool     `json:"valid"`
+	Problems  []string `json:"problems,omitempty"`
+}
+
+var InspectCmd = &cobra.Command{
+	Use:   "inspect [-c=[client_name] [--token=access|refresh|id]] [--json]",
+	Short: "Decode a cached token of [client_name], or a token read from stdin, and show why it would be rejected.",
+	Long: `Decode the header and claims of the cached access, refresh or ID token of the specified client without
+verifying it, show issuer, audience, scopes and iat/nbf/exp with the remaining lifetime, and list the reasons
+get-token would not use the token, e.g. that it expired or expires within the renewal margin. Clients verifying
+their tokens also check the signature. Without --client the token is read from stdin.`,
+	Example: `  tokendokey inspect -c=myclient
+  tokendokey inspect -c=myclient --token=id --json
+  echo "$ACCESS_TOKEN" | tokendokey inspect`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		tokenType, _ := cmd.Flags().GetString("token")
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if tokenType != "access" && tokenType != "refresh" && tokenType != "id" {
+			return fmt.Errorf("unsupported token type: %s", tokenType)
+		}
+
+		var client *tokendokey.Client
+		var token string
+		if clientName != "" {
+			var err error
+			client, err = tokendokey.Load(clientName)
+			if err != nil {
+				return err
+			}
+			token = cachedToken(client, tokenType)
+			if token == "" {
+				return fmt.Errorf("no %s token cached for client %s", tokenType, clientName)
+			}
+		} else {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("reading token from stdin: %w", err)
+			}
+			token = strings.TrimSpace(string(data))
+			if token == "" {
+				return errors.New("no token on stdin, pass a token or --client")
+			}
+		}
+
+		inspection := inspectToken(client, token, tokenType)
+		if jsonOutput {
+			return printJSON(inspection)
+		}
+		printInspection(inspection)
+		return nil
+	},
+}
+
+func init() {
+	InspectCmd.Flags().StringP("client", "c", "", "Client name whose cached token is inspected (the token is read from stdin when empty)")
+	InspectCmd.Flags().String("token", "access", "Token to inspect: access, refresh or id")
+	InspectCmd.Flags().Bool("json", false, "Print the inspection as JSON")
+}
+
+func cachedToken(client *tokendokey.Client, tokenType string) string {
+	switch tokenType {
+	case "access":
+		return client.AccessToken()
+	case "refresh":
+		return client.RefreshToken()
+	}
+	if metadata := client.Metadata(); metadata != nil {
+		return metadata.IDToken
+	}
+	return ""
+}
+
+// Decode token and check it like get-token would, with the metadata of client when it is not nil
+func inspectToken(client *tokendokey.Client, token, tokenType string) *tokenInspection {
+	inspection := &tokenInspection{}
+
+	claims := jwt.MapClaims{}
+	// Numbers kept as they are, large claims like jti counters would lose digits as float64
+	parsed, _, err := jwt.NewParser(jwt.WithJSONNumber()).ParseUnverified(token, claims)
+	if err != nil {
+		inspection.DecodeError = err.Error()
+	} else {
+		inspection.Header = parsed.Header
+		inspection.Claims = claims
+		inspection.Issuer, _ = claims["iss"].(string)
+		inspection.Subject, _ = claims["sub"].(string)
+		inspection.Audience = claimStrings(claims["aud"])
+		if scope, ok := claims["scope"].(string); ok {
+			inspection.Scopes = strings.Fields(scope)
+		} else {
+			inspection.Scopes = claimStrings(claims["scp"])
+		}
+		inspection.IssuedAt = claimTime(claims, "iat")
+		inspection.NotBefore = claimTime(claims, "nbf")
+		inspection.ExpiresAt = claimTime(claims, "exp")
+	}
+
+	// Opaque tokens of a client have their expiry in its metadata, access tokens of unknown expiry
+	// expire when get-token renews them
+	if inspection.ExpiresAt == nil && client != nil {
+		var expiry time.Time
+		var ok bool
+		switch {
+		case tokenType == "access" && token == client.AccessToken():
+			expiry, ok = client.AccessTokenRenewalExpiry()
+		case tokenType == "refresh" && token == client.RefreshToken():
+			expiry, ok = client.RefreshTokenExpiry()
+		}
+		if ok {
+			inspection.ExpiresAt = &expiry
+		}
+	}
+	if inspection.ExpiresAt != nil {
+		expiresIn := int64(time.Until(*inspection.ExpiresAt).Round(time.Second).Seconds())
+		inspection.ExpiresIn = &expiresIn
+	}
+
+	if client != nil && tokenType != "id" {
+		err = client.TokenValidityError(token, tokenType)
+	} else {
+		err = tokendokey.TokenValidityError(token, tokenType)
+	}
+	if err != nil {
+		inspection.Problems = append(inspection.Problems, err.Error())
+	}
+	if inspection.NotBefore != nil && time.Now().Before(*inspection.NotBefore) {
+		inspection.Problems = append(inspection.Problems, fmt.Sprintf("token is not valid before %s", formatClaimTime(*inspection.NotBefore)))
+	}
+	if client != nil && client.Config.VerifyTokens && tokenType != "refresh" {
+		_, err = client.VerifyToken(context.Background(), token, tokenType)
+		if err != nil {
+			inspection.Problems = append(inspection.Problems, err.Error())
+		}
+	}
+	inspection.Valid = len(inspection.Problems) == 0
+	return inspection
+}
+
+func printInspection(inspection *tokenInspection) {
+	// Tokens rejected for their unknown expiry name the decode error in their status already
+	decodeErrorShown := slices.ContainsFunc(inspection.Problems, func(problem string) bool {
+		return strings.Contains(problem, inspection.DecodeError)
+	})
+	if inspection.DecodeError != "" && !decodeErrorShown {
+		fmt.Println("Token is not a JWT:", inspection.DecodeError)
+	}
+	printField := func(name, value string) {
+		if value != "" {
+			fmt.Printf("%-11s %s\n", name+":", value)
+		}
+	}
+	printField("Issuer", inspection.Issuer)
+	printField("Subject", inspection.Subject)
+	printField("Audience", strings.Join(inspection.Audience, ", "))
+	printField("Scopes", strings.Join(inspection.Scopes, " "))
+	for _, field := range []struct {
+		name string
+		time *time.Time
+	}{{"Issued", inspection.IssuedAt}, {"Not before", inspection.NotBefore}, {"Expires", inspection.ExpiresAt}} {
+		if field.time != nil {
+			printField(field.name, formatClaimTime(*field.time))
+		}
+	}
+	if inspection.Valid {
+		printField("Status", "valid")
+	} else {
+		printField("Status", "rejected, "+strings.Join(inspection.Problems, "; "))
+	}
+
+	if inspection.Header != nil {
+		header, _ := json.MarshalIndent(inspection.Header, "", "  ")
+		fmt.Println("Header:", string(header))
+	}
+	if inspection.Claims != nil {
+		claims, _ := json.MarshalIndent(inspection.Claims, "", "  ")
+		fmt.Println("Claims:", string(claims))
+	}
+}
+
+// Time with its distance from now, e.g. 2024-05-01T10:00:00Z (in 4m30s)
+func formatClaimTime(t time.Time) string {
+	distance := time.Until(t).Round(time.Second)
+	if distance < 0 {
+		return fmt.Sprintf("%s (%s ago)", t.UTC().Format(time.RFC3339), -distance)
+	}
+	return fmt.Sprintf("%s (in %s)", t.UTC().Format(time.RFC3339), distance)
+}
+
+func claimTime(claims jwt.MapClaims, name string) *time.Time {
+	value, ok := claims[name].(json.Number)
+	if !ok {
+		return nil
+	}
+	seconds, err := value.Float64()
+	if err != nil {
+		return nil
+	}
+	t := time.Unix(int64(seconds), 0)
+	return &t
+}
+
+// A string or array of strings claim, e.g. aud
+func claimStrings(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/spf13/cobra"
+)
+
+var IntrospectCmd = &cobra.Command{
+	Use:   "introspect -c=[client_name] [--token=access|refresh]",
+	Short: "Ask the server whether the cached token of [client_name] is still active and print the answer as JSON.",
+	Long: `Send the cached access or refresh token of the specified client to the introspection endpoint of the server
+(RFC 7662), authenticated with the client's credentials, and print the response as JSON. The "active" member
+tells whether the server still accepts the token, active tokens are described by members like sub, scope and exp.
+The cached token is sent as it is, without refreshing it first.`,
+	Example: `  tokendokey introspect -c=myclient
+  tokendokey introspect -c=myclient --token=refresh
+  tokendokey introspect -c=myclient | jq .active`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		if clientName == "" {
+			return errors.New("client name is required")
+		}
+		tokenType, _ := cmd.Flags().GetString("token")
+
+		client, err := tokendokey.Load(clientName)
+		if err != nil {
+			return err
+		}
+
+		var token string
+		switch tokenType {
+		case "access":
+			token = client.AccessToken()
+		case "refresh":
+			token = client.RefreshToken()
+		default:
+			return fmt.Errorf("unsupported token type: %s", tokenType)
+		}
+		if token == "" {
+			return fmt.Errorf("no %s token cached for client %s", tokenType, clientName)
+		}
+
+		introspection, err := client.Introspect(context.Background(), token, tokenType+"_token")
+		if err != nil {
+			return fmt.Errorf("introspecting token: %w", err)
+		}
+		return printJSON(introspection)
+	},
+}
+
+func init() {
+	IntrospectCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	IntrospectCmd.Flags().String("token", "access", "Cached token to introspect: access or refresh")
+	IntrospectCmd.MarkFlagRequired("client")
+}
+
+func printJSON(value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
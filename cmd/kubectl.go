@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const execCredentialAPIVersion = "client.authentication.k8s.io/v1"
+
+// ExecCredential of the Kubernetes client authentication API
+type execCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+type execCredentialStatus struct {
+	Token               string `json:"token"`
+	ExpirationTimestamp string `json:"expirationTimestamp,omitempty"`
+}
+
+// users[] entry of a kubeconfig running this command as exec plugin
+type kubeconfigUser struct {
+	Name string `yaml:"name"`
+	User struct {
+		Exec kubeconfigExec `yaml:"exec"`
+	} `yaml:"user"`
+}
+
+type kubeconfigExec struct {
+	APIVersion         string   `yaml:"apiVersion"`
+	Command            string   `yaml:"command"`
+	Args               []string `yaml:"args"`
+	InteractiveMode    string   `yaml:"interactiveMode"`
+	ProvideClusterInfo bool     `yaml:"provideClusterInfo"`
+}
+
+var KubectlCredentialCmd = &cobra.Command{
+	Use:   "kubectl-credential -c=[client_name] [--write-kubeconfig [--kubeconfig=[path]] [--user=[user_name]]]",
+	Short: "Print the access token of [client_name] as Kubernetes ExecCredential for kubectl.",
+	Long: `Print the access token of the specified client as a client.authentication.k8s.io/v1 ExecCredential,
+so kubectl can use tokendokey as exec credential plugin. The expiration timestamp is taken from the token metadata or the exp claim of the token.
+With --write-kubeconfig the matching users[].exec entry is written to the kubeconfig file instead.`,
+	Example: `  tokendokey kubectl-credential -c=myclient
+  tokendokey kubectl-credential -c=myclient --write-kubeconfig
+  tokendokey kubectl-credential -c=myclient --write-kubeconfig --kubeconfig=path/to/kubeconfig --user=oidc`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		if clientName == "" {
+			return errors.New("client name is required")
+		}
+
+		writeKubeconfig, _ := cmd.Flags().GetBool("write-kubeconfig")
+		if writeKubeconfig {
+			kubeconfigPath, _ := cmd.Flags().GetString("kubeconfig")
+			if kubeconfigPath == "" {
+				kubeconfigPath = defaultKubeconfigPath()
+			}
+			userName, _ := cmd.Flags().GetString("user")
+			if userName == "" {
+				userName = clientName
+			}
+
+			err := writeKubeconfigUser(kubeconfigPath, userName, clientName)
+			if err != nil {
+				return fmt.Errorf("writing kubeconfig: %w", err)
+			}
+			fmt.Printf("User %s added to %s, reference it from a context to use it.\n", userName, kubeconfigPath)
+			return nil
+		}
+
+		accessToken, err := accessTokenFor(clientName, false)
+		if err != nil {
+			// Printed on stderr, which kubectl shows to the user, stdout must stay an ExecCredential
+			return fmt.Errorf("getting access token: %w", err)
+		}
+
+		credential := execCredential{
+			APIVersion: execCredentialAPIVersion,
+			Kind:       "ExecCredential",
+			Status:     execCredentialStatus{Token: accessToken},
+		}
+		if expiry, ok := accessTokenExpiry(clientName, accessToken); ok {
+			credential.Status.ExpirationTimestamp = expiry.UTC().Format(time.RFC3339)
+		}
+
+		credentialJSON, _ := json.MarshalIndent(credential, "", "  ")
+		fmt.Println(string(credentialJSON))
+		return nil
+	},
+}
+
+func init() {
+	KubectlCredentialCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	KubectlCredentialCmd.Flags().Bool("write-kubeconfig", false, "Write the exec plugin user entry to the kubeconfig instead of printing a credential")
+	KubectlCredentialCmd.Flags().String("kubeconfig", "", "Kubeconfig file to write (first KUBECONFIG entry or ~/.kube/config when empty)")
+	KubectlCredentialCmd.Flags().String("user", "", "Name of the kubeconfig user entry (client name when empty)")
+	KubectlCredentialCmd.MarkFlagRequired("client")
+}
+
+func defaultKubeconfigPath() string {
+	if paths := filepath.SplitList(os.Getenv("KUBECONFIG")); len(paths) > 0 && paths[0] != "" {
+		return paths[0]
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".kube", "config")
+}
+
+// Add or replace the named user in the kubeconfig, keeping the rest of the file as is
+func writeKubeconfigUser(kubeconfigPath, userName, clientName string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var user kubeconfigUser
+	user.Name = userName
+	user.User.Exec = kubeconfigExec{
+		APIVersion:      execCredentialAPIVersion,
+		Command:         executable,
+		Args:            []string{"kubectl-credential", "--client=" + clientName},
+		InteractiveMode: "Never",
+	}
+	var userNode yaml.Node
+	err = userNode.Encode(user)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	data, err := os.ReadFile(kubeconfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		var root yaml.Node
+		root.Encode(map[string]interface{}{"apiVersion": "v1", "kind": "Config"})
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&root}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a kubeconfig", kubeconfigPath)
+	}
+
+	users := mappingValue(root, "users")
+	if users == nil || users.Kind != yaml.SequenceNode {
+		if users == nil {
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "users"}, &yaml.Node{Kind: yaml.SequenceNode})
+			users = root.Content[len(root.Content)-1]
+		} else {
+			*users = yaml.Node{Kind: yaml.SequenceNode}
+		}
+	}
+
+	replaced := false
+	for i, existing := range users.Content {
+		if name := mappingValue(existing, "name"); name != nil && name.Value == userName {
+			users.Content[i] = &userNode
+			replaced = true
+		}
+	}
+	if !replaced {
+		users.Content = append(users.Content, &userNode)
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&doc)
+	if err != nil {
+		return err
+	}
+	// Replace the file a symlinked kubeconfig points to, not the symlink
+	if target, err := filepath.EvalSymlinks(kubeconfigPath); err == nil {
+		kubeconfigPath = target
+	}
+	return tokendokey.WriteFile(kubeconfigPath, out.Bytes())
+}
+
+// Value of key in a YAML mapping node, nil when absent
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
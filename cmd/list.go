@@ -3,10 +3,10 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
 	"strings"
 
+	"tokendokey/pkg/tokendokey"
+
 	"github.com/spf13/cobra"
 )
 
@@ -16,13 +16,12 @@ var ListCmd = &cobra.Command{
 	Long:  `List all clients for the current user. Or display settings for a specific client.`,
 	Example: `  tokendokey list
   tokendokey list -c=myclient`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		clientName, _ := cmd.Flags().GetString("client")
 		if clientName == "" {
-			listAllClients()
-		} else {
-			displayClientSettings(clientName)
+			return listAllClients()
 		}
+		return displayClientSettings(clientName)
 	},
 }
 
@@ -30,36 +29,32 @@ func init() {
 	ListCmd.Flags().StringP("client", "c", "", "Specify the client name")
 }
 
-func listAllClients() {
-	configDir := filepath.Join(getHomeDir(), ".tokendokey")
-	// dir := ".tokendokey"
-	files, err := os.ReadDir(configDir)
+func listAllClients() error {
+	clientNames, err := tokendokey.Clients()
 	if err != nil {
-		fmt.Println("Error reading .tokendokey directory:", err)
-		return
+		return fmt.Errorf("reading .tokendokey directory: %w", err)
 	}
 
 	fmt.Println("Current user have the following client settings in .tokendokey directory:")
-	for _, file := range files {
-		if file.IsDir() {
-			fmt.Println(file.Name())
-		}
+	for _, clientName := range clientNames {
+		fmt.Println(clientName)
 	}
+	return nil
 }
 
-func displayClientSettings(clientName string) {
-	configPath := filepath.Join(getHomeDir(), ".tokendokey", clientName, "config.json")
-	// configPath := filepath.Join(".tokendokey", clientName, "config.json")
-	data, err := os.ReadFile(configPath)
+func displayClientSettings(clientName string) error {
+	store, err := tokendokey.DefaultStore()
+	if err != nil {
+		return err
+	}
+	data, err := store.Get(clientName, "config.json")
 	if err != nil {
-		fmt.Println("Error reading config.json for client", clientName, ":", err)
-		return
+		return fmt.Errorf("reading config.json for client %s: %w", clientName, err)
 	}
 
 	var config map[string]interface{}
 	if err := json.Unmarshal(data, &config); err != nil {
-		fmt.Println("Error parsing config.json for client", clientName, ":", err)
-		return
+		return fmt.Errorf("parsing config.json for client %s: %w", clientName, err)
 	}
 
 	if clientSecret, ok := config["client_secret"].(string); ok && clientSecret != "" {
@@ -68,12 +63,12 @@ func displayClientSettings(clientName string) {
 
 	configJSON, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
-		fmt.Println("Error formatting config.json for client", clientName, ":", err)
-		return
+		return fmt.Errorf("formatting config.json for client %s: %w", clientName, err)
 	}
 
 	fmt.Println("Current user has the following settings for client:", clientName)
 	fmt.Println(string(configJSON))
+	return nil
 }
 
 func maskString(s string) string {
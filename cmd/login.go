@@ -1,183 +1,64 @@
 package cmd
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"os"
-	"path/filepath"
-	"strings"
-	"time"
+
+	"tokendokey/pkg/tokendokey"
 
 	"github.com/spf13/cobra"
 )
 
-// Generate a cryptographically secure random string as the code verifier
-func generateCodeVerifier() (string, error) {
-	verifier := make([]byte, 43)
-	_, err := rand.Read(verifier)
-	if err != nil {
-		return "", err
-	}
-	return base64.RawURLEncoding.EncodeToString(verifier), nil
-}
-
-// Hash the code verifier using SHA-256 and base64 URL encode it to create the code challenge
-func generateCodeChallenge(verifier string) (string, error) {
-	hash := sha256.Sum256([]byte(verifier))
-	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
-}
-
 var LoginCmd = &cobra.Command{
-	Use:   "login -c=[client_name] [-o|--offline-token]",
+	Use:   "login -c=[client_name] [-o|--offline-token] [--flow=device|authcode]",
 	Short: "Login to [client_name] through OAuth service using Device Code flow. When [offline-token] is provided, will get offline token instead of a regular refresh token.",
 	Long: `Login to the specified client through the OAuth service using the Device Code flow.
-If the -ot|--offline-token flag is provided, an offline token will be obtained instead of a regular refresh token.`,
+If the -ot|--offline-token flag is provided, an offline token will be obtained instead of a regular refresh token.
+With --flow=authcode the Authorization Code flow with PKCE is used instead, receiving the code on a
+local 127.0.0.1 listener (random port unless --port is given).`,
 	Example: `  tokendokey login -c=myclient
 	tokendokey login -c=myclient -o
   tokendokey login --client=myclient
-  tokendokey login --client=myclient --offline-token`,
+  tokendokey login --client=myclient --offline-token
+  tokendokey login -c=myclient --flow=authcode --open
+  tokendokey login -c=myclient --flow=authcode --port=8400`,
 	Args: cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		clientName, _ := cmd.Flags().GetString("client")
 		if clientName == "" {
-			fmt.Println("Error: client name is required")
-			return
+			return errors.New("client name is required")
 		}
 
 		offlineToken, _ := cmd.Flags().GetBool("offline-token")
-
-		configDir := filepath.Join(getHomeDir(), ".tokendokey", clientName)
-		configFilePath := filepath.Join(configDir, "config.json")
-		refreshTokenPath := filepath.Join(configDir, "refresh_token.txt")
-		accessTokenPath := filepath.Join(configDir, "access_token.txt")
-
-		// Load configuration
-		var config Config
-		configData, err := os.ReadFile(configFilePath)
-		if err != nil {
-			fmt.Println("Error loading configuration:", err)
-			return
-		}
-		err = json.Unmarshal(configData, &config)
-		if err != nil {
-			fmt.Println("Error unmarshaling configuration:", err)
-			return
+		flow, _ := cmd.Flags().GetString("flow")
+		if flow != tokendokey.FlowDevice && flow != tokendokey.FlowAuthCode {
+			return fmt.Errorf("unsupported flow: %s", flow)
 		}
+		port, _ := cmd.Flags().GetInt("port")
+		open, _ := cmd.Flags().GetBool("open")
 
-		// Generate code verifier and challenge
-		verifier, err := generateCodeVerifier()
-		if err != nil {
-			fmt.Println("Error generating code verifier:", err)
-			return
-		}
-		challenge, err := generateCodeChallenge(verifier)
+		client, err := tokendokey.Load(clientName)
 		if err != nil {
-			fmt.Println("Error generating code challenge:", err)
-			return
-		}
-
-		// Request device code
-		deviceCodeURL := config.DeviceCodeURL
-		deviceCodeReq := url.Values{
-			"client_id":             {config.ClientID},
-			"code_challenge":        {challenge},
-			"code_challenge_method": {"S256"},
-		}
-		if config.ClientSecret != "" {
-			deviceCodeReq.Set("client_secret", config.ClientSecret)
-		}
-
-		if offlineToken {
-			deviceCodeReq.Add("scope", "email profile offline_access")
+			return err
 		}
 
-		deviceCodeResp, err := http.Post(deviceCodeURL, "application/x-www-form-urlencoded", strings.NewReader(deviceCodeReq.Encode()))
+		options := tokendokey.LoginOptions{Flow: flow, OfflineToken: offlineToken, Port: port}
+		err = client.Login(context.Background(), terminalPrompter{openBrowser: open}, options)
 		if err != nil {
-			fmt.Println("Error requesting device code:", err)
-			return
-		}
-		defer deviceCodeResp.Body.Close()
-
-		body, _ := io.ReadAll(deviceCodeResp.Body)
-		var deviceCodeResponse map[string]string
-		json.Unmarshal(body, &deviceCodeResponse)
-
-		if deviceCodeResponse["error"] != "" {
-			fmt.Println("Error requesting device code:", deviceCodeResponse["error"])
-			return
-		}
-
-		// Prompt user to visit URL and enter code
-		verificationURI := deviceCodeResponse["verification_uri_complete"]
-		if verificationURI == "" {
-			verificationURI = deviceCodeResponse["verification_uri"]
-			fmt.Println("Please visit the following URL and enter the code:")
-			fmt.Println(verificationURI)
-			fmt.Println("Enter the user code:", deviceCodeResponse["user_code"])
-		} else {
-			fmt.Println("Please visit the following URL and enter the code:")
-			fmt.Println(verificationURI)
-		}
-		// Prompt user to visit URL and enter code, once done from browser, press any key to continue.
-		fmt.Println("Once finshed on browser, Press any key to continue...")
-		fmt.Scanln()
-
-		// Poll for authorization
-		pollURL := config.TokenIssueURL
-		pollReq := url.Values{
-			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
-			"device_code":   {deviceCodeResponse["device_code"]},
-			"client_id":     {config.ClientID},
-			"code_verifier": {verifier},
+			return fmt.Errorf("logging in: %w", err)
 		}
-		if config.ClientSecret != "" {
-			pollReq.Set("client_secret", config.ClientSecret)
-		}
-		for {
-			pollResp, err := http.Post(pollURL, "application/x-www-form-urlencoded", strings.NewReader(pollReq.Encode()))
-			if err != nil {
-				fmt.Println("Error polling for authorization:", err)
-				return
-			}
-
-			defer pollResp.Body.Close()
-
-			if pollResp.StatusCode == http.StatusOK {
-				body, _ := io.ReadAll(pollResp.Body)
-				var pollResponse map[string]string
-				json.Unmarshal(body, &pollResponse)
-
-				if pollResponse["access_token"] != "" {
-					// Authorization successful, obtain access token
-					newAccessToken := pollResponse["access_token"]
-					newrefreshToken := pollResponse["refresh_token"]
-					// Write access token to file
-					os.WriteFile(accessTokenPath, []byte(newAccessToken), 0644)
-					os.WriteFile(refreshTokenPath, []byte(newrefreshToken), 0644)
 
-					fmt.Println("User logged in successfully, please use [tokendokey get-token --client=yourclient] to retrieve your Access token.")
-
-					return
-				}
-			}
-
-			// Authorization not yet granted, wait and try again
-			fmt.Println("Error polling for authorization: Response code", pollResp.Status)
-			fmt.Println("Keep trying in 5 seconds. Waiting for you to open the URL above. Or exit")
-			time.Sleep(5 * time.Second)
-
-		}
+		fmt.Println("User logged in successfully, please use [tokendokey get-token --client=yourclient] to retrieve your Access token.")
+		return nil
 	},
 }
 
 func init() {
 	LoginCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
 	LoginCmd.Flags().BoolP("offline-token", "o", false, "Get offline token instead of a regular refresh token")
+	LoginCmd.Flags().String("flow", tokendokey.FlowDevice, "Login flow to use: device or authcode")
+	LoginCmd.Flags().Int("port", 0, "Port of the local redirect listener for the authcode flow (random when 0)")
+	LoginCmd.Flags().Bool("open", false, "Open the login URL in the default browser")
 	LoginCmd.MarkFlagRequired("client")
 }
@@ -1,38 +1,74 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+
+	"tokendokey/pkg/tokendokey"
 
 	"github.com/spf13/cobra"
 )
 
 var LogoutCmd = &cobra.Command{
-	Use:   "logout -c=[client_name]",
-	Short: "Logout from the specified client by simply remove access and refresh tokens.",
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:   "logout -c=[client_name] [--local-only]",
+	Short: "Logout from the specified client, revoking its tokens at the server and removing them locally.",
+	Long: `Logout from the specified client. The refresh token, ending the session, the access token and the tokens
+it was exchanged for are revoked at the revocation endpoint of the server (RFC 7009) before they are removed locally. When revocation fails
+the tokens are kept, so logout can be run again. With --local-only the tokens are only removed locally and
+stay valid at the server until they expire, offline tokens possibly indefinitely.
+When the server has an end_session_endpoint, the URL ending the browser session is printed as well.`,
+	Example: `  tokendokey logout -c=myclient
+  tokendokey logout -c=myclient --local-only`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		clientName, _ := cmd.Flags().GetString("client")
+		localOnly, _ := cmd.Flags().GetBool("local-only")
 
-		configDir := filepath.Join(getHomeDir(), ".tokendokey", clientName)
-		refreshTokenPath := filepath.Join(configDir, "refresh_token.txt")
-		accessTokenPath := filepath.Join(configDir, "access_token.txt")
-
-		err := os.Remove(refreshTokenPath)
+		client, err := tokendokey.Load(clientName)
 		if err != nil {
-			fmt.Println("Error removing refresh token:", err)
+			return err
+		}
+
+		hasTokens := client.RefreshToken() != "" || client.AccessToken() != "" || client.HasExchangedTokens()
+		revoked := false
+		if !localOnly && hasTokens {
+			err = client.RevokeTokens(context.Background())
+			switch {
+			case errors.Is(err, tokendokey.ErrRevocationNotSupported):
+				fmt.Fprintln(os.Stderr, "Warning: the server has no revocation endpoint, the tokens are only removed locally and stay valid until they expire.")
+			case err != nil:
+				return fmt.Errorf("%w\nThe tokens are kept, run logout again or use --local-only to remove them without revoking", err)
+			default:
+				revoked = true
+			}
 		}
 
-		err = os.Remove(accessTokenPath)
+		// Read before the ID token is removed with the other tokens, only when there was a session
+		endSessionURL := ""
+		if !localOnly && client.RefreshToken() != "" {
+			endSessionURL = client.EndSessionURL()
+		}
+
+		err = client.Logout()
 		if err != nil {
-			fmt.Println("Error removing access token:", err)
+			return fmt.Errorf("removing tokens: %w", err)
 		}
 
+		if revoked {
+			fmt.Println("Tokens revoked at the server.")
+		}
 		fmt.Println("Logged out successfully.")
+		if endSessionURL != "" {
+			fmt.Println("To also end the browser session, open:", endSessionURL)
+		}
+		return nil
 	},
 }
 
 func init() {
 	LogoutCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	LogoutCmd.Flags().Bool("local-only", false, "Only remove the tokens locally, without revoking them at the server")
 	LogoutCmd.MarkFlagRequired("client")
 }
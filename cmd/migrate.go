@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/spf13/cobra"
+)
+
+var MigrateCmd = &cobra.Command{
+	Use:   "migrate [--decrypt]",
+	Short: "Encrypt the configuration and tokens of all clients with a passphrase.",
+	Long: `Encrypt the configuration and tokens of all clients in ~/.tokendokey with a key derived from a passphrase
+(scrypt, AES-GCM) and restrict the files to the current user. Commands then ask for the passphrase, read it from
+TOKENDOKEY_PASSPHRASE or get the key from a running agent. Running migrate again encrypts files left in plaintext,
+e.g. after an interrupted migration. With --decrypt the files are written back in plaintext.`,
+	Example: `  tokendokey migrate
+  TOKENDOKEY_PASSPHRASE=secret tokendokey migrate
+  tokendokey migrate --decrypt`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		decrypt, _ := cmd.Flags().GetBool("decrypt")
+		if backend := tokendokey.DefaultStoreBackend(); backend != tokendokey.StoreFile {
+			return fmt.Errorf("only the file store can be encrypted, TOKENDOKEY_STORE is %s", backend)
+		}
+
+		dir, err := tokendokey.DefaultDir()
+		if err != nil {
+			return err
+		}
+		encryption, err := tokendokey.LoadEncryption(dir)
+		if err != nil {
+			return fmt.Errorf("loading encryption settings: %w", err)
+		}
+		if decrypt && encryption == nil {
+			return errors.New("the token store is not encrypted")
+		}
+
+		var key []byte
+		if encryption == nil {
+			encryption, key, err = newStoreEncryption()
+			if err != nil {
+				return err
+			}
+			// Saved first, so an interrupted migration can be completed by running it again
+			err = encryption.Save(dir)
+			if err != nil {
+				return fmt.Errorf("saving encryption settings: %w", err)
+			}
+		} else {
+			key, err = unlockStore(encryption)
+			if err != nil {
+				return fmt.Errorf("unlocking token store: %w", err)
+			}
+		}
+
+		fileStore := &tokendokey.FileStore{Dir: dir}
+		encryptedStore, err := tokendokey.NewEncryptedStore(fileStore, key)
+		if err != nil {
+			return err
+		}
+
+		migrated, err := migrateStore(fileStore, encryptedStore, decrypt)
+		if err != nil {
+			return fmt.Errorf("migrating token store: %w", err)
+		}
+
+		if decrypt {
+			err = tokendokey.RemoveEncryption(dir)
+			if err != nil {
+				return fmt.Errorf("removing encryption settings: %w", err)
+			}
+			fmt.Println("Decrypted", migrated, "files, the token store is no longer encrypted.")
+			return nil
+		}
+		fmt.Println("Encrypted", migrated, "files, the token store is encrypted.")
+		return nil
+	},
+}
+
+func init() {
+	MigrateCmd.Flags().Bool("decrypt", false, "Decrypt the token store back to plaintext files")
+}
+
+// Passphrase from TOKENDOKEY_PASSPHRASE or asked twice on the terminal
+func newStoreEncryption() (*tokendokey.Encryption, []byte, error) {
+	passphrase := os.Getenv(tokendokey.PassphraseEnv)
+	if passphrase == "" {
+		var err error
+		passphrase, err = readPassphrase("Enter new passphrase of the token store: ")
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading passphrase: %w, set %s", err, tokendokey.PassphraseEnv)
+		}
+		confirmation, err := readPassphrase("Repeat the passphrase: ")
+		if err != nil {
+			return nil, nil, err
+		}
+		if confirmation != passphrase {
+			return nil, nil, fmt.Errorf("the passphrases do not match")
+		}
+	}
+	return tokendokey.NewEncryption(passphrase)
+}
+
+// Rewrite every value of every client that is not yet in the target format, return how many were rewritten
+func migrateStore(fileStore *tokendokey.FileStore, encryptedStore *tokendokey.EncryptedStore, decrypt bool) (int, error) {
+	// Folders created before the store restricted them keep their mode otherwise
+	err := os.Chmod(fileStore.Dir, 0700)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	clientNames, err := fileStore.List()
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, clientName := range clientNames {
+		count, err := migrateClient(fileStore, encryptedStore, clientName, decrypt)
+		migrated += count
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// Rewrite the values of a client, locked so a concurrent refresh neither reads a value in the middle of
+// the migration nor writes one in the old format
+func migrateClient(fileStore *tokendokey.FileStore, encryptedStore *tokendokey.EncryptedStore, clientName string, decrypt bool) (int, error) {
+	unlock, err := fileStore.Lock(clientName)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	err = filepath.WalkDir(filepath.Join(fileStore.Dir, clientName), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		return os.Chmod(path, 0700)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	keys, err := fileStore.Keys(clientName)
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, key := range keys {
+		data, err := fileStore.Get(clientName, key)
+		if err != nil {
+			return migrated, err
+		}
+		if tokendokey.IsEncrypted(data) != decrypt {
+			continue
+		}
+
+		if decrypt {
+			data, err = encryptedStore.Get(clientName, key)
+			if err == nil {
+				err = fileStore.Put(clientName, key, data)
+			}
+		} else {
+			err = encryptedStore.Put(clientName, key, data)
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("%s of %s: %w", key, clientName, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
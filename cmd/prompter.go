@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// Prints login instructions on the terminal, optionally opening the browser
+type terminalPrompter struct {
+	openBrowser bool
+}
+
+func (p terminalPrompter) Authorize(ctx context.Context, verificationURL, userCode string) error {
+	fmt.Println("Please visit the following URL to login:")
+	fmt.Println(verificationURL)
+	if userCode != "" {
+		fmt.Println("Enter the user code:", userCode)
+	}
+	if p.openBrowser {
+		if err := openBrowser(verificationURL); err != nil {
+			fmt.Println("Unable to open browser, please open the URL above manually:", err)
+		}
+	}
+	fmt.Println("Waiting for you to finish on browser...")
+	return nil
+}
+
+// Open the URL in the default browser of the current OS
+func openBrowser(target string) error {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", target).Start()
+	case "darwin":
+		return exec.Command("open", target).Start()
+	default:
+		return exec.Command("xdg-open", target).Start()
+	}
+}
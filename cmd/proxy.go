@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultProxyListen = "127.0.0.1:8080"
+	// Request bodies up to this size are buffered so a 401 can be retried with a refreshed token
+	maxRetryBodySize = 1 << 20
+)
+
+// Requests below Prefix are sent to Upstream with the access token of Client
+type proxyRoute struct {
+	Prefix   string
+	Client   *tokendokey.Client
+	Upstream *url.URL
+	handler  http.Handler
+}
+
+var ProxyCmd = &cobra.Command{
+	Use:   "proxy -c=[client_name] --upstream=[url] [--listen=[address]] [--route=[prefix]=[client_name][,[url]]...]",
+	Short: "Run a local reverse proxy adding the access token of [client_name] to requests.",
+	Long: `Run a local reverse proxy forwarding requests to the upstream with the access token of the specified client
+as bearer token, for tools that cannot add tokens themselves. The token is refreshed when it expires and a request
+answered with 401 is retried once with a refreshed token. With --route requests below a path prefix are sent with
+the token of another client, and to another upstream when given. The request path is forwarded unchanged.`,
+	Example: `  tokendokey proxy -c=myclient --upstream=https://api.internal
+  tokendokey proxy -c=myclient --upstream=https://api.internal --listen=127.0.0.1:9090
+  tokendokey proxy -c=myclient --upstream=https://api.internal --route=/billing=billingclient,https://billing.internal`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		upstream, _ := cmd.Flags().GetString("upstream")
+		listen, _ := cmd.Flags().GetString("listen")
+		routeSpecs, _ := cmd.Flags().GetStringArray("route")
+
+		if clientName == "" && len(routeSpecs) == 0 {
+			return errors.New("client name or route is required")
+		}
+		if clientName != "" && upstream == "" {
+			return errors.New("upstream is required")
+		}
+
+		// Routes of the same client share it, so concurrent requests refresh its token once
+		clients := map[string]*tokendokey.Client{}
+		newRoute := func(prefix, name, upstream string) (*proxyRoute, error) {
+			client, ok := clients[name]
+			if !ok {
+				var err error
+				client, err = tokendokey.Load(name)
+				if err != nil {
+					return nil, err
+				}
+				clients[name] = client
+			}
+			upstreamURL, err := url.Parse(upstream)
+			if err != nil || upstreamURL.Scheme == "" || upstreamURL.Host == "" {
+				return nil, fmt.Errorf("invalid upstream URL: %s", upstream)
+			}
+			return &proxyRoute{Prefix: prefix, Client: client, Upstream: upstreamURL}, nil
+		}
+
+		var routes []*proxyRoute
+		for _, spec := range routeSpecs {
+			prefix, target, ok := strings.Cut(spec, "=")
+			name, routeUpstream, _ := strings.Cut(target, ",")
+			if !ok || !strings.HasPrefix(prefix, "/") || name == "" {
+				return fmt.Errorf("invalid route, expected /prefix=client[,upstream]: %s", spec)
+			}
+			if routeUpstream == "" {
+				routeUpstream = upstream
+			}
+			if routeUpstream == "" {
+				return fmt.Errorf("route has no upstream: %s", spec)
+			}
+			route, err := newRoute(prefix, name, routeUpstream)
+			if err != nil {
+				return err
+			}
+			routes = append(routes, route)
+		}
+		if clientName != "" {
+			route, err := newRoute("/", clientName, upstream)
+			if err != nil {
+				return err
+			}
+			routes = append(routes, route)
+		}
+
+		// Longest prefix wins
+		sort.SliceStable(routes, func(i, j int) bool {
+			return len(routes[i].Prefix) > len(routes[j].Prefix)
+		})
+		for _, route := range routes {
+			route.handler = newAuthorizingProxy(route)
+			fmt.Printf("Proxying http://%s%s to %s with client %s\n", listen, route.Prefix, route.Upstream, route.Client.Name)
+		}
+
+		err := http.ListenAndServe(listen, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, route := range routes {
+				if route.matches(r.URL.Path) {
+					route.handler.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.NotFound(w, r)
+		}))
+		if err != nil {
+			return fmt.Errorf("running proxy: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	ProxyCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration of requests not matching a route")
+	ProxyCmd.Flags().String("upstream", "", "URL requests are forwarded to")
+	ProxyCmd.Flags().String("listen", defaultProxyListen, "Address the proxy listens on")
+	ProxyCmd.Flags().StringArray("route", nil, "Send requests below a path prefix with another client, as /prefix=client[,upstream] (repeatable)")
+}
+
+func (route *proxyRoute) matches(path string) bool {
+	prefix := strings.TrimSuffix(route.Prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func newAuthorizingProxy(route *proxyRoute) http.Handler {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(route.Upstream)
+			r.SetXForwarded()
+			// The caller's credentials are replaced by the client's token
+			r.Out.Header.Del("Authorization")
+			bufferRequestBody(r.Out)
+		},
+		Transport: &tokendokey.Transport{Client: route.Client},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Error proxying %s %s with client %s: %v", r.Method, r.URL.Path, route.Client.Name, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		},
+	}
+}
+
+// Make small request bodies replayable, Transport only retries requests with GetBody
+func bufferRequestBody(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength < 0 || req.ContentLength > maxRetryBodySize {
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	// A short body fails the upstream request with a Content-Length mismatch
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
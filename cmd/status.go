@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/cobra"
+)
+
+// State of a client's tokens, printed by status
+type clientStatus struct {
+	Client      string `json:"client"`
+	GrantType   string `json:"grant_type,omitempty"`
+	IssuerHost  string `json:"issuer_host,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	AccessToken bool   `json:"access_token"`
+	// Expiry of the access token, nil when there is none or it is unknown
+	AccessTokenExpiresAt *time.Time `json:"access_token_expires_at,omitempty"`
+	RefreshToken         bool       `json:"refresh_token"`
+	// Expiry of the refresh token, nil for offline tokens and when it is unknown
+	RefreshTokenExpiresAt *time.Time `json:"refresh_token_expires_at,omitempty"`
+	OfflineToken          bool       `json:"offline_token,omitempty"`
+	// cached, refresh or grant when the next get-token succeeds without the user, login when it needs one
+	NextGetToken string `json:"next_get_token,omitempty"`
+	// Why the grant of the next get-token fails without involving the user, e.g. a missing assertion file
+	Problem string `json:"problem,omitempty"`
+	Silent  bool   `json:"silent"`
+	Error   string `json:"error,omitempty"`
+}
+
+var StatusCmd = &cobra.Command{
+	Use:   "status [--json]",
+	Short: "Show the token state of all clients and whether get-token would need a login.",
+	Long: `Show every configured client with its grant type, issuer host, logged-in subject, the expiry of its access and
+refresh token (offline tokens do not expire) and what the next get-token does: use the cached token, refresh it,
+run the client's grant, or fail because the user has to login. Grants that would fail anyway are reported too,
+e.g. a missing assertion file or a token exchange whose subject client needs a login. Nothing is sent to the
+server, except fetching the key set of clients verifying their tokens when it is not cached.
+With --json the same is printed as a JSON array, e.g. for dashboards.`,
+	Example: `  tokendokey status
+  tokendokey status --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+
+		clientNames, err := tokendokey.Clients()
+		if err != nil {
+			return fmt.Errorf("listing clients: %w", err)
+		}
+
+		statuses := make([]*clientStatus, 0, len(clientNames))
+		for _, clientName := range clientNames {
+			statuses = append(statuses, statusOf(clientName))
+		}
+
+		if jsonOutput {
+			return printJSON(statuses)
+		}
+		printStatusTable(statuses)
+		return nil
+	},
+}
+
+func init() {
+	StatusCmd.Flags().Bool("json", false, "Print the status as JSON")
+}
+
+func statusOf(clientName string) *clientStatus {
+	status := &clientStatus{Client: clientName}
+	client, err := tokendokey.Load(clientName)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	config := client.Config
+
+	status.GrantType = config.GrantType
+	if status.GrantType == "" {
+		status.GrantType = tokendokey.GrantDeviceCode
+	}
+	status.IssuerHost = urlHost(config.Issuer)
+	if status.IssuerHost == "" {
+		status.IssuerHost = urlHost(config.TokenIssueURL)
+	}
+
+	accessToken := client.AccessToken()
+	refreshToken := client.RefreshToken()
+	status.AccessToken = accessToken != ""
+	status.RefreshToken = refreshToken != ""
+	if expiry, ok := client.AccessTokenRenewalExpiry(); ok && status.AccessToken {
+		status.AccessTokenExpiresAt = &expiry
+	}
+	if expiry, ok := client.RefreshTokenExpiry(); ok && status.RefreshToken {
+		status.RefreshTokenExpiresAt = &expiry
+	}
+	status.OfflineToken = status.RefreshToken && isOfflineToken(client, refreshToken)
+
+	var idToken string
+	if metadata := client.Metadata(); metadata != nil {
+		idToken = metadata.IDToken
+	}
+	for _, token := range []string{idToken, accessToken, refreshToken} {
+		if subject := tokenSubject(token); subject != "" {
+			status.Subject = subject
+			break
+		}
+	}
+
+	status.NextGetToken = client.NextAction(context.Background())
+	if status.NextGetToken == tokendokey.NextGrant {
+		status.Problem = grantProblem(client)
+	}
+	status.Silent = status.NextGetToken != tokendokey.NextLogin && status.Problem == ""
+	return status
+}
+
+// Why the grant of a client cannot succeed on its own, empty when nothing is known to stop it
+func grantProblem(client *tokendokey.Client) string {
+	config := client.Config
+	switch config.GrantType {
+	case tokendokey.GrantJWTBearer:
+		assertion, err := os.ReadFile(config.AssertionFile)
+		if err != nil {
+			return fmt.Sprintf("reading assertion file: %v", err)
+		}
+		if strings.TrimSpace(string(assertion)) == "" {
+			return "assertion file is empty"
+		}
+	case tokendokey.GrantTokenExchange:
+		// Rejects cycles, the statuses of the subject and actor clients below cannot recurse forever
+		err := tokendokey.ValidateExchange(client.Store, client.Name, config)
+		if err != nil {
+			return err.Error()
+		}
+		for _, dependency := range []struct{ role, name string }{{"subject", config.SubjectClient}, {"actor", config.ActorClient}} {
+			if dependency.name == "" {
+				continue
+			}
+			status := statusOf(dependency.name)
+			switch {
+			case status.Error != "":
+				return fmt.Sprintf("%s client %s: %s", dependency.role, dependency.name, status.Error)
+			case status.Problem != "":
+				return fmt.Sprintf("%s client %s: %s", dependency.role, dependency.name, status.Problem)
+			case !status.Silent:
+				return fmt.Sprintf("%s client %s: login required", dependency.role, dependency.name)
+			}
+		}
+	}
+	return ""
+}
+
+// Whether the refresh token is an offline token, which does not expire with the user's session
+func isOfflineToken(client *tokendokey.Client, refreshToken string) bool {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(refreshToken, claims); err == nil {
+		// Keycloak marks offline tokens with typ Offline
+		if typ, _ := claims["typ"].(string); strings.EqualFold(typ, "offline") {
+			return true
+		}
+		if scope, ok := claims["scope"].(string); ok && containsField(scope, "offline_access") {
+			return true
+		}
+	}
+	metadata := client.Metadata()
+	return metadata != nil && containsField(metadata.Scope, "offline_access")
+}
+
+func containsField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// sub claim of a JWT, empty for opaque tokens
+func tokenSubject(token string) string {
+	if token == "" {
+		return ""
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return ""
+	}
+	subject, _ := claims["sub"].(string)
+	return subject
+}
+
+func urlHost(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+func printStatusTable(statuses []*clientStatus) {
+	if len(statuses) == 0 {
+		fmt.Println("No clients configured, run tokendokey init to add one.")
+		return
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "CLIENT\tGRANT\tISSUER\tSUBJECT\tACCESS TOKEN\tREFRESH TOKEN\tNEXT GET-TOKEN")
+	for _, status := range statuses {
+		if status.Error != "" {
+			fmt.Fprintf(table, "%s\t-\t-\t-\t-\t-\terror: %s\n", status.Client, status.Error)
+			continue
+		}
+
+		refreshToken := tokenExpiryText(status.RefreshToken, status.RefreshTokenExpiresAt)
+		if status.OfflineToken && status.RefreshTokenExpiresAt == nil {
+			refreshToken = "offline"
+		}
+		next := status.NextGetToken
+		switch {
+		case next == tokendokey.NextLogin:
+			next = "login required"
+		case status.Problem != "":
+			next += " fails: " + status.Problem
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", status.Client, status.GrantType, dash(status.IssuerHost),
+			dash(status.Subject), tokenExpiryText(status.AccessToken, status.AccessTokenExpiresAt), refreshToken, next)
+	}
+	table.Flush()
+}
+
+// Expiry of a token relative to now, e.g. "expires in 4m30s"
+func tokenExpiryText(present bool, expiry *time.Time) string {
+	switch {
+	case !present:
+		return "-"
+	case expiry == nil:
+		return "no expiry"
+	}
+	remaining := time.Until(*expiry).Round(time.Second)
+	if remaining <= 0 {
+		return fmt.Sprintf("expired %s ago", -remaining)
+	}
+	return fmt.Sprintf("expires in %s", remaining)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"tokendokey/pkg/tokendokey"
+
+	"golang.org/x/term"
+)
+
+var (
+	unlockMu sync.Mutex
+	// Key of the encrypted store once unlocked, served by the agent to other commands
+	unlockedKey []byte
+)
+
+func init() {
+	tokendokey.UnlockFunc = unlockStore
+}
+
+// Key of the encrypted store, from TOKENDOKEY_PASSPHRASE, the agent or a passphrase prompt
+func unlockStore(encryption *tokendokey.Encryption) ([]byte, error) {
+	unlockMu.Lock()
+	defer unlockMu.Unlock()
+	if unlockedKey != nil {
+		return unlockedKey, nil
+	}
+
+	key, err := storeKey(encryption)
+	if err != nil {
+		return nil, err
+	}
+	unlockedKey = key
+	return key, nil
+}
+
+func storeKey(encryption *tokendokey.Encryption) ([]byte, error) {
+	if passphrase := os.Getenv(tokendokey.PassphraseEnv); passphrase != "" {
+		return encryption.Key(passphrase)
+	}
+
+	if socketPath := os.Getenv(agentSockEnv); socketPath != "" {
+		response, err := callAgent(socketPath, agentRequest{Command: "unlock"})
+		if err == nil {
+			key, err := base64.StdEncoding.DecodeString(response.Key)
+			if err == nil && encryption.CheckKey(key) == nil {
+				return key, nil
+			}
+		}
+	}
+
+	passphrase, err := readPassphrase("Enter passphrase of the token store: ")
+	if errors.Is(err, errNoTerminal) {
+		return nil, tokendokey.ErrStoreLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encryption.Key(passphrase)
+}
+
+var errNoTerminal = errors.New("no terminal to read the passphrase from")
+
+// Read a passphrase without echo, the prompt goes to stderr so stdout stays usable in scripts
+func readPassphrase(prompt string) (string, error) {
+	stdin := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdin) {
+		return "", errNoTerminal
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(stdin)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(passphrase), nil
+}
+
+// Key of the default store, nil when it is not encrypted
+func defaultStoreKey() ([]byte, error) {
+	if tokendokey.DefaultStoreBackend() != tokendokey.StoreFile {
+		return nil, nil
+	}
+	dir, err := tokendokey.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	encryption, err := tokendokey.LoadEncryption(dir)
+	if err != nil || encryption == nil {
+		return nil, err
+	}
+	return unlockStore(encryption)
+}
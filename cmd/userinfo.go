@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/spf13/cobra"
+)
+
+var UserInfoCmd = &cobra.Command{
+	Use:   "userinfo -c=[client_name]",
+	Short: "Print the claims of the userinfo endpoint about the user of [client_name] as JSON.",
+	Long: `Call the OpenID Connect userinfo endpoint with the access token of the specified client and print the
+claims about the user as JSON. The access token is refreshed first when it is about to expire, and once more
+when the endpoint rejects it.`,
+	Example: `  tokendokey userinfo -c=myclient
+  tokendokey userinfo -c=myclient | jq -r .email`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		if clientName == "" {
+			return errors.New("client name is required")
+		}
+
+		client, err := tokendokey.Load(clientName)
+		if err != nil {
+			return err
+		}
+
+		claims, err := client.UserInfo(context.Background())
+		if err != nil {
+			return fmt.Errorf("getting userinfo: %w", err)
+		}
+		return printJSON(claims)
+	},
+}
+
+func init() {
+	UserInfoCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	UserInfoCmd.MarkFlagRequired("client")
+}
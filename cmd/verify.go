@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tokendokey/pkg/tokendokey"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/spf13/cobra"
+)
+
+var VerifyCmd = &cobra.Command{
+	Use:   "verify -c=[client_name] [--token=access|id] [--enable|--disable]",
+	Short: "Verify the cached token of [client_name] against the key set of its issuer.",
+	Long: `Verify the signature of the cached access or ID token of the specified client with the key set published
+at the jwks_uri of its issuer, and check its iss, aud, nbf and exp claims against the client configuration.
+The key set is cached with the client and fetched again when a token is signed with an unknown key.
+With --enable the client verifies every access token before get-token and the other commands use it,
+--disable turns that off again.`,
+	Example: `  tokendokey verify -c=myclient
+  tokendokey verify -c=myclient --token=id
+  tokendokey verify -c=myclient --enable`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clientName, _ := cmd.Flags().GetString("client")
+		if clientName == "" {
+			return errors.New("client name is required")
+		}
+		tokenType, _ := cmd.Flags().GetString("token")
+		enable, _ := cmd.Flags().GetBool("enable")
+		disable, _ := cmd.Flags().GetBool("disable")
+		if enable && disable {
+			return errors.New("--enable and --disable are mutually exclusive")
+		}
+
+		client, err := tokendokey.Load(clientName)
+		if err != nil {
+			return err
+		}
+
+		if enable || disable {
+			if enable {
+				// Fails early for clients initialized without issuer or jwks_uri
+				_, err = client.UpdateJWKS(context.Background())
+				if err != nil {
+					return fmt.Errorf("fetching key set: %w", err)
+				}
+				if client.Config.Issuer == "" {
+					return fmt.Errorf("client %s has no issuer, initialize it again to fetch it from the discovery document", clientName)
+				}
+			}
+			client.Config.VerifyTokens = enable
+			err = client.SaveConfig()
+			if err != nil {
+				return fmt.Errorf("saving configuration: %w", err)
+			}
+			if enable {
+				fmt.Println("Token verification enabled for client:", clientName)
+			} else {
+				fmt.Println("Token verification disabled for client:", clientName)
+			}
+			return nil
+		}
+
+		var token string
+		switch tokenType {
+		case "access":
+			token = client.AccessToken()
+		case "id":
+			if metadata := client.Metadata(); metadata != nil {
+				token = metadata.IDToken
+			}
+		default:
+			return fmt.Errorf("unsupported token type: %s", tokenType)
+		}
+		if token == "" {
+			return fmt.Errorf("no %s token cached for client %s", tokenType, clientName)
+		}
+
+		claims, err := client.VerifyToken(context.Background(), token, tokenType)
+		if err != nil {
+			return err
+		}
+		printVerifiedClaims(claims)
+		return nil
+	},
+}
+
+func init() {
+	VerifyCmd.Flags().StringP("client", "c", "", "Client name for the OAuth configuration")
+	VerifyCmd.Flags().String("token", "access", "Cached token to verify: access or id")
+	VerifyCmd.Flags().Bool("enable", false, "Verify access tokens of the client whenever they are used")
+	VerifyCmd.Flags().Bool("disable", false, "Stop verifying access tokens of the client")
+	VerifyCmd.MarkFlagRequired("client")
+}
+
+func printVerifiedClaims(claims jwt.MapClaims) {
+	fmt.Println("Token is valid.")
+	for _, name := range []string{"iss", "sub", "aud", "azp"} {
+		if value, ok := claims[name]; ok {
+			fmt.Printf("  %s: %v\n", name, value)
+		}
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		fmt.Printf("  exp: %s\n", time.Unix(int64(exp), 0).UTC().Format(time.RFC3339))
+	}
+}
@@ -3,8 +3,14 @@ module tokendokey
 go 1.22.1
 
 require (
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/golang-jwt/jwt/v4 v4.5.0
 	github.com/spf13/cobra v1.8.1
+	golang.org/x/crypto v0.31.0
+	golang.org/x/oauth2 v0.21.0
+	golang.org/x/sys v0.28.0
+	golang.org/x/term v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+
 	"tokendokey/cmd"
 
 	"github.com/spf13/cobra"
@@ -8,6 +12,10 @@ import (
 
 func main() {
 	var rootCmd = &cobra.Command{Use: "tokendokey"}
+	// Usage is only printed for invalid arguments, not for errors of the command itself
+	rootCmd.PersistentPreRun = func(c *cobra.Command, args []string) {
+		c.SilenceUsage = true
+	}
 
 	rootCmd.AddCommand(cmd.InitCmd)
 	rootCmd.AddCommand(cmd.GetTokenCmd)
@@ -18,6 +26,29 @@ func main() {
 	rootCmd.AddCommand(cmd.ListCmd)
 	rootCmd.AddCommand(cmd.DeleteCmd)
 	rootCmd.AddCommand(cmd.MTLSTokenCmd)
+	rootCmd.AddCommand(cmd.ExchangeCmd)
+	rootCmd.AddCommand(cmd.AgentCmd)
+	rootCmd.AddCommand(cmd.KubectlCredentialCmd)
+	rootCmd.AddCommand(cmd.DockerCredentialCmd)
+	rootCmd.AddCommand(cmd.GitCredentialCmd)
+	rootCmd.AddCommand(cmd.ExecCmd)
+	rootCmd.AddCommand(cmd.ProxyCmd)
+	rootCmd.AddCommand(cmd.MigrateCmd)
+	rootCmd.AddCommand(cmd.VerifyCmd)
+	rootCmd.AddCommand(cmd.IntrospectCmd)
+	rootCmd.AddCommand(cmd.UserInfoCmd)
+	rootCmd.AddCommand(cmd.InspectCmd)
+	rootCmd.AddCommand(cmd.StatusCmd)
+
+	// Docker runs credential helpers as docker-credential-<name> <action>
+	executable := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	if executable == cmd.DockerCredentialHelperName {
+		rootCmd.SetArgs(append([]string{"docker-credential"}, os.Args[1:]...))
+	}
 
-	rootCmd.Execute()
+	// Cobra prints the error on stderr
+	err := rootCmd.Execute()
+	if err != nil {
+		os.Exit(cmd.ExitCode(err))
+	}
 }
@@ -0,0 +1,125 @@
+package tokendokey
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	clientAssertionType     = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionLifetime = 60 * time.Second
+)
+
+// Post a form to an endpoint of the authorization server, authenticating the client as configured
+func (c *Client) postForm(ctx context.Context, httpClient *http.Client, endpoint string, form url.Values) (*http.Response, error) {
+	config := c.Config
+
+	authForm := url.Values{}
+	for key, values := range form {
+		authForm[key] = values
+	}
+	authForm.Set("client_id", config.ClientID)
+
+	var basicAuth bool
+	switch config.TokenEndpointAuthMethod {
+	case "", AuthClientSecretPost:
+		// Public clients have no secret to send
+		if config.ClientSecret != "" {
+			authForm.Set("client_secret", config.ClientSecret)
+		}
+	case AuthClientSecretBasic:
+		basicAuth = true
+	case AuthClientSecretJWT, AuthPrivateKeyJWT:
+		assertion, err := clientAssertion(config)
+		if err != nil {
+			return nil, fmt.Errorf("creating client assertion: %w", err)
+		}
+		authForm.Set("client_assertion_type", clientAssertionType)
+		authForm.Set("client_assertion", assertion)
+	default:
+		return nil, fmt.Errorf("unsupported token_endpoint_auth_method: %s", config.TokenEndpointAuthMethod)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(authForm.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basicAuth {
+		// RFC 6749 section 2.3.1 requires form encoding of the credentials
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+
+	return httpClient.Do(req)
+}
+
+// Create a signed JWT authenticating the client, RFC 7523 section 2.2
+func clientAssertion(config Config) (string, error) {
+	jti, err := randomString(24)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    config.ClientID,
+		Subject:   config.ClientID,
+		Audience:  jwt.ClaimStrings{config.TokenIssueURL},
+		ID:        jti,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	}
+
+	if config.TokenEndpointAuthMethod == AuthClientSecretJWT {
+		if config.ClientSecret == "" {
+			return "", fmt.Errorf("client_secret_jwt needs a client secret")
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.ClientSecret))
+	}
+
+	if config.PrivateKeyPath == "" {
+		return "", fmt.Errorf("private_key_jwt needs a private_key_path")
+	}
+	keyData, err := os.ReadFile(config.PrivateKeyPath)
+	if err != nil {
+		return "", err
+	}
+
+	var token *jwt.Token
+	var key interface{}
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(keyData); err == nil {
+		token, key = jwt.NewWithClaims(jwt.SigningMethodRS256, claims), rsaKey
+	} else if ecKey, err := jwt.ParseECPrivateKeyFromPEM(keyData); err == nil {
+		token, key = jwt.NewWithClaims(ecSigningMethod(ecKey), claims), ecKey
+	} else if edKey, err := jwt.ParseEdPrivateKeyFromPEM(keyData); err == nil {
+		token, key = jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims), edKey
+	} else {
+		return "", fmt.Errorf("unsupported private key in %s", config.PrivateKeyPath)
+	}
+
+	if config.KeyID != "" {
+		token.Header["kid"] = config.KeyID
+	}
+	return token.SignedString(key)
+}
+
+// Pick the ECDSA algorithm matching the curve of the key
+func ecSigningMethod(key *ecdsa.PrivateKey) jwt.SigningMethod {
+	switch key.Curve {
+	case elliptic.P384():
+		return jwt.SigningMethodES384
+	case elliptic.P521():
+		return jwt.SigningMethodES512
+	default:
+		return jwt.SigningMethodES256
+	}
+}
@@ -0,0 +1,371 @@
+// Package tokendokey retrieves and caches OAuth/OIDC tokens of the clients configured
+// with the tokendokey command in ~/.tokendokey.
+package tokendokey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	configFile       = "config.json"
+	accessTokenFile  = "access_token.txt"
+	refreshTokenFile = "refresh_token.txt"
+	// A rotated refresh token until it replaced refresh_token.txt, recovers from a failed write
+	refreshTokenPendingFile = "refresh_token.new"
+)
+
+const jwtBearerGrant = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// What the next Token call of a client does
+const (
+	// Return the cached access token
+	NextCached = "cached"
+	// Get a new access token with the refresh token
+	NextRefresh = "refresh"
+	// Run the non-interactive grant of the client
+	NextGrant = "grant"
+	// Fail with ErrLoginRequired
+	NextLogin = "login"
+)
+
+// A configured OAuth client and its cached tokens
+type Client struct {
+	Name   string
+	Config Config
+	// Holds the configuration and tokens of the client
+	Store Store
+	// Used for all requests to the authorization server, http.DefaultClient when nil
+	HTTPClient *http.Client
+
+	// Serializes refreshes of concurrent callers, e.g. a shared Transport
+	mu sync.Mutex
+}
+
+// Folder holding the configuration of all clients, ~/.tokendokey
+func DefaultDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".tokendokey"), nil
+}
+
+// Load the named client from the default store
+func Load(name string) (*Client, error) {
+	store, err := DefaultStore()
+	if err != nil {
+		return nil, err
+	}
+	return LoadFrom(store, name)
+}
+
+// Load the named client from store
+func LoadFrom(store Store, name string) (*Client, error) {
+	if name == "" {
+		return nil, fmt.Errorf("client name is required")
+	}
+
+	configData, err := store.Get(name, configFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading configuration: %w", err)
+	}
+	var config Config
+	err = json.Unmarshal(configData, &config)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling configuration: %w", err)
+	}
+
+	return &Client{Name: name, Config: config, Store: store}, nil
+}
+
+// Create the named client in the default store with empty token caches. Tokens, metadata and the key set
+// of a previous configuration of the client are removed, they belong to another session or issuer.
+func Create(name string, config Config) (*Client, error) {
+	if name == "" {
+		return nil, fmt.Errorf("client name is required")
+	}
+	store, err := DefaultStore()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{Name: name, Config: config, Store: store}
+	err = c.SaveConfig()
+	if err != nil {
+		return nil, err
+	}
+	err = errors.Join(c.Logout(), c.Store.Remove(c.Name, jwksFile))
+	if err != nil {
+		return nil, fmt.Errorf("removing previous tokens: %w", err)
+	}
+	c.writeFile(refreshTokenFile, "")
+	c.writeFile(accessTokenFile, "")
+	return c, nil
+}
+
+// Names of all clients in the default store
+func Clients() ([]string, error) {
+	store, err := DefaultStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.List()
+}
+
+// Remove the named client and all its cached tokens from the default store
+func Remove(name string) error {
+	if name == "" {
+		return fmt.Errorf("client name is required")
+	}
+	store, err := DefaultStore()
+	if err != nil {
+		return err
+	}
+	return store.Delete(name)
+}
+
+// Write the configuration of the client back to its store
+func (c *Client) SaveConfig() error {
+	configData, _ := json.MarshalIndent(c.Config, "", "  ")
+	return c.Store.Put(c.Name, configFile, configData)
+}
+
+func (c *Client) readFile(file string) string {
+	data, _ := c.Store.Get(c.Name, file)
+	return string(data)
+}
+
+func (c *Client) writeFile(file, data string) error {
+	return c.Store.Put(c.Name, file, []byte(data))
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Cached access token, empty when none
+func (c *Client) AccessToken() string {
+	return c.readFile(accessTokenFile)
+}
+
+// Cached refresh token, empty when none. A rotated refresh token whose write to refresh_token.txt
+// failed is still pending, it replaced the cached one at the server.
+func (c *Client) RefreshToken() string {
+	if refreshToken := c.readFile(refreshTokenPendingFile); refreshToken != "" {
+		return refreshToken
+	}
+	return c.readFile(refreshTokenFile)
+}
+
+// Store the tokens of a token endpoint response and their metadata. Servers that do not
+// rotate refresh tokens return none on refresh, the cached one stays valid then.
+// Callers hold c.mu and the client lock.
+func (c *Client) saveTokens(tokens *tokenResponse) error {
+	// Only user clients carry a refresh token
+	refreshToken, rotated := "", false
+	if c.Config.IsUserClient() {
+		previous := c.RefreshToken()
+		refreshToken = tokens.RefreshToken
+		if refreshToken == "" {
+			refreshToken = previous
+		}
+		rotated = previous != "" && refreshToken != previous
+
+		// The rotated token is kept pending before it replaces the previous one, which the server no longer accepts
+		if rotated {
+			err := c.writeFile(refreshTokenPendingFile, refreshToken)
+			if err != nil {
+				return err
+			}
+		}
+		if refreshToken != previous || previous == "" {
+			err := c.writeFile(refreshTokenFile, refreshToken)
+			if err != nil {
+				return err
+			}
+		}
+		if rotated {
+			err := c.Store.Remove(c.Name, refreshTokenPendingFile)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := c.writeFile(accessTokenFile, tokens.AccessToken)
+	if err != nil {
+		return err
+	}
+	metadata := newTokenMetadata(tokens, refreshToken, c.Metadata())
+	metadata.RefreshTokenRotated = rotated
+	return c.writeMetadata(metadataFile, metadata)
+}
+
+// Remove the cached access and refresh tokens
+func (c *Client) Logout() error {
+	errs := []error{
+		c.Store.Remove(c.Name, refreshTokenFile),
+		c.Store.Remove(c.Name, refreshTokenPendingFile),
+		c.Store.Remove(c.Name, accessTokenFile),
+		c.Store.Remove(c.Name, metadataFile),
+	}
+	// Exchanged tokens were issued on behalf of the session as well
+	errs = append(errs, c.clearExchangedTokens())
+	return errors.Join(errs...)
+}
+
+// Return a valid access token, from the cache or by running the client's grant
+func (c *Client) Token(ctx context.Context) (string, error) {
+	return c.token(ctx, false, "")
+}
+
+// Get a new access token even if the cached one is still valid
+func (c *Client) Refresh(ctx context.Context) (string, error) {
+	return c.token(ctx, true, "")
+}
+
+// Get a new access token after the server rejected failedToken. When the cached token is no longer
+// failedToken, another caller rejected with the same token already refreshed it and it is returned instead.
+func (c *Client) RefreshIfStale(ctx context.Context, failedToken string) (string, error) {
+	return c.token(ctx, false, failedToken)
+}
+
+func (c *Client) token(ctx context.Context, forceRefresh bool, failedToken string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	accessToken := c.AccessToken()
+	if !forceRefresh && accessToken != failedToken && c.AccessTokenUsable(ctx, accessToken) {
+		return accessToken, nil
+	}
+
+	// Only one process refreshes, a rotated refresh token is useless to the others
+	unlock, err := c.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Reuse the token another process got while this one waited for the lock
+	if lockedToken := c.AccessToken(); lockedToken != accessToken && lockedToken != failedToken && c.AccessTokenUsable(ctx, lockedToken) {
+		return lockedToken, nil
+	}
+
+	form, err := c.grantForm(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	tokens, err := c.requestToken(ctx, c.httpClient(), c.Config.TokenIssueURL, form)
+	if err != nil && form.Get("grant_type") == "refresh_token" {
+		return "", refreshError(err)
+	}
+	if err != nil {
+		return "", err
+	}
+	err = c.saveTokens(tokens)
+	if err != nil {
+		return "", err
+	}
+	// Verified after saving, a rotated refresh token must not be lost
+	if c.Config.VerifyTokens {
+		_, err = c.VerifyToken(ctx, tokens.AccessToken, "access")
+		if err != nil {
+			return "", fmt.Errorf("verifying access token: %w", err)
+		}
+	}
+	return tokens.AccessToken, nil
+}
+
+// What the next Token call does, decided like Token but without requesting a token.
+// Clients verifying their tokens may fetch the key set of the issuer.
+func (c *Client) NextAction(ctx context.Context) string {
+	if c.AccessTokenUsable(ctx, c.AccessToken()) {
+		return NextCached
+	}
+	return c.renewAction()
+}
+
+// How a new access token is got: NextGrant, NextRefresh, or NextLogin when only a login helps
+func (c *Client) renewAction() string {
+	switch {
+	case !c.Config.IsUserClient():
+		return NextGrant
+	case c.refreshTokenValid():
+		return NextRefresh
+	}
+	return NextLogin
+}
+
+// Whether the access token is valid for a while longer and, when the client verifies
+// tokens, signed by its issuer. Token returns the cached access token while it is usable.
+func (c *Client) AccessTokenUsable(ctx context.Context, accessToken string) bool {
+	if !c.accessTokenValid(accessToken) {
+		return false
+	}
+	if c.Config.VerifyTokens {
+		_, err := c.VerifyToken(ctx, accessToken, "access")
+		return err == nil
+	}
+	return true
+}
+
+// Lock the client against other processes when the store supports it
+func (c *Client) lock() (func(), error) {
+	if locker, ok := c.Store.(Locker); ok {
+		return locker.Lock(c.Name)
+	}
+	return func() {}, nil
+}
+
+// Build the token request of the client's grant type
+func (c *Client) grantForm(ctx context.Context) (url.Values, error) {
+	config := c.Config
+
+	switch config.GrantType {
+	case GrantClientCredentials:
+		form := url.Values{"grant_type": {"client_credentials"}}
+		if config.Scope != "" {
+			form.Set("scope", config.Scope)
+		}
+		if config.Audience != "" {
+			form.Set("audience", config.Audience)
+		}
+		return form, nil
+	case GrantJWTBearer:
+		assertion, err := os.ReadFile(config.AssertionFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading assertion file: %w", err)
+		}
+		form := url.Values{
+			"grant_type": {jwtBearerGrant},
+			"assertion":  {strings.TrimSpace(string(assertion))},
+		}
+		if config.Scope != "" {
+			form.Set("scope", config.Scope)
+		}
+		return form, nil
+	case GrantTokenExchange:
+		return c.exchangeGrantForm(ctx)
+	default:
+		if c.renewAction() == NextLogin {
+			return nil, ErrLoginRequired
+		}
+		return url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {c.RefreshToken()},
+		}, nil
+	}
+}
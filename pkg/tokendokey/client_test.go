@@ -0,0 +1,266 @@
+package tokendokey
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Two processes refreshing the same client must not both use its refresh token: the server
+// rotates it, the second refresh would be rejected as reuse and end the session.
+func TestConcurrentRefreshSharesRotatedToken(t *testing.T) {
+	var refreshes atomic.Int32
+	refreshing := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("grant_type") != "refresh_token" {
+			t.Errorf("unexpected grant_type %q", r.PostForm.Get("grant_type"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("refresh_token") != "refresh-0" || refreshes.Add(1) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "Maximum allowed refresh token reuse exceeded"})
+			return
+		}
+		// Keep the lock held while the other caller starts waiting for it
+		close(refreshing)
+		time.Sleep(200 * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access-1",
+			"refresh_token": "refresh-1",
+			"token_type":    "Bearer",
+			"expires_in":    300,
+		})
+	}))
+	defer server.Close()
+
+	store := &FileStore{Dir: t.TempDir()}
+	config, _ := json.Marshal(Config{ClientID: "test", TokenIssueURL: server.URL, GrantType: GrantDeviceCode})
+	if err := store.Put("test", configFile, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("test", refreshTokenFile, []byte("refresh-0")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Separate clients share only the store and its file lock, like separate processes
+	holder, err := LoadFrom(store, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := LoadFrom(store, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var holderToken, waiterToken string
+	var holderErr, waiterErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		holderToken, holderErr = holder.Token(context.Background())
+	}()
+	<-refreshing
+	go func() {
+		defer wg.Done()
+		waiterToken, waiterErr = waiter.Token(context.Background())
+	}()
+	wg.Wait()
+
+	if holderErr != nil {
+		t.Fatalf("lock holder: %v", holderErr)
+	}
+	if waiterErr != nil {
+		t.Fatalf("waiting caller: %v", waiterErr)
+	}
+	if got := refreshes.Load(); got != 1 {
+		t.Errorf("refresh requests = %d, want 1", got)
+	}
+	if holderToken != "access-1" || waiterToken != holderToken {
+		t.Errorf("tokens = %q and %q, want both %q", holderToken, waiterToken, "access-1")
+	}
+	if got := waiter.RefreshToken(); got != "refresh-1" {
+		t.Errorf("cached refresh token = %q, want %q", got, "refresh-1")
+	}
+}
+
+// Client named name with config in a new FileStore
+func newTestClient(t *testing.T, name string, config Config) *Client {
+	t.Helper()
+	return addTestClient(t, &FileStore{Dir: t.TempDir()}, name, config)
+}
+
+// Client named name with config in store
+func addTestClient(t *testing.T, store Store, name string, config Config) *Client {
+	t.Helper()
+	data, _ := json.Marshal(config)
+	if err := store.Put(name, configFile, data); err != nil {
+		t.Fatal(err)
+	}
+	client, err := LoadFrom(store, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// Write a token endpoint response
+func writeTokenResponse(w http.ResponseWriter, status int, response map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Prompter accepting every authorization request
+type testPrompter struct{}
+
+func (testPrompter) Authorize(ctx context.Context, verificationURL, userCode string) error {
+	return nil
+}
+
+// A login must not store its tokens while another process refreshes, the refresh would overwrite
+// the new refresh token with the one it got for the previous session
+func TestLoginWaitsForClientLock(t *testing.T) {
+	server := newExchangeServer(t)
+	store := &FileStore{Dir: t.TempDir()}
+	client := addTestClient(t, store, "user", Config{ClientID: "user", TokenIssueURL: server.URL + "/token", DeviceCodeURL: server.URL + "/device"})
+
+	unlock, err := store.Lock("user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Login(context.Background(), testPrompter{}, LoginOptions{})
+	}()
+
+	select {
+	case err := <-done:
+		unlock()
+		t.Fatalf("Login returned while the client was locked: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if got := client.RefreshToken(); got != "" {
+		t.Errorf("refresh token stored while the client was locked: %q", got)
+	}
+
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := client.RefreshToken(); got != "user-refresh-1" {
+		t.Errorf("RefreshToken = %q, want %q", got, "user-refresh-1")
+	}
+}
+
+// Browser following the authorization URL: it first calls the redirect URI with a forged state,
+// then with the state of the login and code
+type callbackPrompter struct {
+	code      string
+	responses chan int
+}
+
+func (p callbackPrompter) Authorize(ctx context.Context, verificationURL, userCode string) error {
+	authURL, err := url.Parse(verificationURL)
+	if err != nil {
+		return err
+	}
+	query := authURL.Query()
+	go func() {
+		for _, state := range []string{"forged", query.Get("state")} {
+			callback := query.Get("redirect_uri") + "?" + url.Values{"state": {state}, "code": {p.code}}.Encode()
+			resp, err := http.Get(callback)
+			if err != nil {
+				p.responses <- 0
+				continue
+			}
+			resp.Body.Close()
+			p.responses <- resp.StatusCode
+		}
+	}()
+	return nil
+}
+
+// A callback with another state must be refused without ending the login, the real one may still follow
+func TestAuthCodeLoginIgnoresForgedState(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != "code-1" {
+			writeTokenResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid_grant"})
+			return
+		}
+		writeTokenResponse(w, http.StatusOK, map[string]interface{}{"access_token": "access-1", "refresh_token": "refresh-1", "expires_in": 300})
+	}))
+	defer server.Close()
+	client := newTestClient(t, "user", Config{ClientID: "user", TokenIssueURL: server.URL, AuthorizationURL: server.URL + "/authorize"})
+
+	prompter := callbackPrompter{code: "code-1", responses: make(chan int, 2)}
+	if err := client.Login(context.Background(), prompter, LoginOptions{Flow: FlowAuthCode}); err != nil {
+		t.Fatal(err)
+	}
+	if forged, real := <-prompter.responses, <-prompter.responses; forged != http.StatusBadRequest || real != http.StatusOK {
+		t.Errorf("callback statuses = %d and %d, want %d and %d", forged, real, http.StatusBadRequest, http.StatusOK)
+	}
+	if got := client.RefreshToken(); got != "refresh-1" {
+		t.Errorf("RefreshToken = %q, want %q", got, "refresh-1")
+	}
+}
+
+// Access tokens without expires_in or exp are renewed DefaultAccessTokenLifetime after they were issued,
+// callers showing or passing on their expiry must get that time
+func TestAccessTokenRenewalExpiry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeTokenResponse(w, http.StatusOK, map[string]interface{}{"access_token": "opaque", "token_type": "Bearer"})
+	}))
+	defer server.Close()
+	client := newTestClient(t, "service", Config{ClientID: "service", ClientSecret: "secret", TokenIssueURL: server.URL, GrantType: GrantClientCredentials})
+
+	before := time.Now()
+	if _, err := client.Token(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.AccessTokenExpiry(); ok {
+		t.Error("AccessTokenExpiry known for a token without expiry")
+	}
+	expiry, ok := client.AccessTokenRenewalExpiry()
+	if !ok {
+		t.Fatal("AccessTokenRenewalExpiry unknown")
+	}
+	if earliest := before.Add(DefaultAccessTokenLifetime).Add(-time.Second); expiry.Before(earliest) || expiry.After(time.Now().Add(DefaultAccessTokenLifetime)) {
+		t.Errorf("AccessTokenRenewalExpiry = %s, want about %s after %s", expiry, DefaultAccessTokenLifetime, before)
+	}
+}
+
+// UserInfo wraps the transport of the configured HTTP client and must keep its timeout
+func TestUserInfoKeepsHTTPClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/userinfo" {
+			select {
+			case <-release:
+			case <-time.After(2 * time.Second):
+			}
+			return
+		}
+		writeTokenResponse(w, http.StatusOK, map[string]interface{}{"access_token": "access-1", "token_type": "Bearer", "expires_in": 300})
+	}))
+	defer server.Close()
+	defer close(release)
+	client := newTestClient(t, "service", Config{ClientID: "service", ClientSecret: "secret", TokenIssueURL: server.URL + "/token", UserInfoURL: server.URL + "/userinfo", GrantType: GrantClientCredentials})
+	client.HTTPClient = &http.Client{Timeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	if _, err := client.UserInfo(context.Background()); err == nil {
+		t.Fatal("UserInfo succeeded without a response")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("UserInfo returned after %s, the client timeout is 100ms", elapsed)
+	}
+}
@@ -0,0 +1,120 @@
+package tokendokey
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type Config struct {
+	ClientID      string `json:"client_id"`
+	ClientSecret  string `json:"client_secret"`
+	TokenIssueURL string `json:"token_issue_url"`
+	DeviceCodeURL string `json:"device_authorization_endpoint"`
+	// Only required for the authcode login flow
+	AuthorizationURL string `json:"authorization_endpoint,omitempty"`
+	// Empty means a user client logged in with the login command
+	GrantType string `json:"grant_type,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Audience  string `json:"audience,omitempty"`
+	// Clients whose access tokens are exchanged by a token_exchange client
+	SubjectClient string `json:"subject_client,omitempty"`
+	ActorClient   string `json:"actor_client,omitempty"`
+	// How the client authenticates to the token endpoint, client_secret_post when empty
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"`
+	// PEM private key and its key ID for private_key_jwt
+	PrivateKeyPath string `json:"private_key_path,omitempty"`
+	KeyID          string `json:"key_id,omitempty"`
+	// File holding the assertion of jwt_bearer clients, read again on every refresh
+	AssertionFile string `json:"assertion_file,omitempty"`
+	// Issuer and key set location from the discovery document, used to verify tokens
+	Issuer  string `json:"issuer,omitempty"`
+	JWKSURI string `json:"jwks_uri,omitempty"`
+	// Verify the signature and claims of access tokens before handing them out
+	VerifyTokens bool `json:"verify_tokens,omitempty"`
+	// Endpoints ending the session on logout, RFC 7009 and OpenID Connect RP-Initiated Logout
+	RevocationURL string `json:"revocation_endpoint,omitempty"`
+	EndSessionURL string `json:"end_session_endpoint,omitempty"`
+	// Endpoints describing tokens and their user, RFC 7662 and OpenID Connect Core 5.3
+	IntrospectionURL string `json:"introspection_endpoint,omitempty"`
+	UserInfoURL      string `json:"userinfo_endpoint,omitempty"`
+}
+
+// Grant types a client can be initialized with
+const (
+	GrantDeviceCode        = "device_code"
+	GrantClientCredentials = "client_credentials"
+	GrantTokenExchange     = "token_exchange"
+	GrantJWTBearer         = "jwt_bearer"
+)
+
+// Client authentication methods for the token endpoint, as registered in OpenID Connect Discovery
+const (
+	AuthClientSecretPost  = "client_secret_post"
+	AuthClientSecretBasic = "client_secret_basic"
+	AuthClientSecretJWT   = "client_secret_jwt"
+	AuthPrivateKeyJWT     = "private_key_jwt"
+)
+
+// Endpoints read from an OAuth/OIDC discovery document
+type Discovery struct {
+	Issuer                      string `json:"issuer"`
+	JWKSURI                     string `json:"jwks_uri"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	RevocationEndpoint          string `json:"revocation_endpoint"`
+	EndSessionEndpoint          string `json:"end_session_endpoint"`
+	IntrospectionEndpoint       string `json:"introspection_endpoint"`
+	UserInfoEndpoint            string `json:"userinfo_endpoint"`
+}
+
+func IsSupportedGrantType(grantType string) bool {
+	switch grantType {
+	case GrantDeviceCode, GrantClientCredentials, GrantTokenExchange, GrantJWTBearer:
+		return true
+	}
+	return false
+}
+
+func IsSupportedAuthMethod(method string) bool {
+	switch method {
+	case "", AuthClientSecretPost, AuthClientSecretBasic, AuthClientSecretJWT, AuthPrivateKeyJWT:
+		return true
+	}
+	return false
+}
+
+// Whether the client holds a refresh token obtained by a user login
+func (c Config) IsUserClient() bool {
+	return c.GrantType == "" || c.GrantType == GrantDeviceCode
+}
+
+// Fetch the OAuth/OIDC discovery document at discoveryURL
+func Discover(ctx context.Context, discoveryURL string) (*Discovery, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching discovery document: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var discovery Discovery
+	err = json.Unmarshal(body, &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("parsing discovery document: %w", err)
+	}
+	return &discovery, nil
+}
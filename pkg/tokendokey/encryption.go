@@ -0,0 +1,228 @@
+package tokendokey
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// Environment variable holding the passphrase of an encrypted store
+	PassphraseEnv = "TOKENDOKEY_PASSPHRASE"
+
+	encryptionFile = "encryption.json"
+	kdfScrypt      = "scrypt"
+	keyLength      = 32
+)
+
+// Prefix of encrypted values, followed by the nonce and the AES-GCM sealed data
+var encryptedPrefix = []byte("TDKE1")
+
+// Known plaintext sealed with the key, to tell a wrong passphrase from corrupt data
+var encryptionCheck = []byte("tokendokey")
+
+// Key derivation parameters of an encrypted store, kept in encryption.json next to the clients
+type Encryption struct {
+	KDF  string `json:"kdf"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+	// encryptionCheck sealed with the derived key
+	Check []byte `json:"check"`
+}
+
+// Store encrypting the values of another store with AES-GCM. The client name and key are
+// authenticated with every value, so values cannot be swapped between files.
+type EncryptedStore struct {
+	Store Store
+	aead  cipher.AEAD
+}
+
+// Encryption parameters of the store in dir, nil when the store is not encrypted
+func LoadEncryption(dir string) (*Encryption, error) {
+	data, err := os.ReadFile(filepath.Join(dir, encryptionFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var encryption Encryption
+	err = json.Unmarshal(data, &encryption)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling %s: %w", encryptionFile, err)
+	}
+	if encryption.KDF != kdfScrypt {
+		return nil, fmt.Errorf("unsupported key derivation function: %s", encryption.KDF)
+	}
+	return &encryption, nil
+}
+
+// New encryption parameters with a random salt and the key derived from passphrase
+func NewEncryption(passphrase string) (*Encryption, []byte, error) {
+	if passphrase == "" {
+		return nil, nil, fmt.Errorf("passphrase is required")
+	}
+	encryption := &Encryption{KDF: kdfScrypt, N: 1 << 15, R: 8, P: 1, Salt: make([]byte, 16)}
+	_, err := rand.Read(encryption.Salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := encryption.deriveKey(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	encryption.Check, err = seal(aead, encryptionCheck, []byte(encryptionFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	return encryption, key, nil
+}
+
+// Save the parameters to dir, the store is encrypted from then on
+func (e *Encryption) Save(dir string) error {
+	data, _ := json.MarshalIndent(e, "", "  ")
+	return WriteFile(filepath.Join(dir, encryptionFile), data)
+}
+
+// Derive the key from passphrase, ErrWrongPassphrase when it does not match the store
+func (e *Encryption) Key(passphrase string) ([]byte, error) {
+	key, err := e.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	err = e.CheckKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Verify a key obtained elsewhere, e.g. from an agent, ErrWrongPassphrase when it does not match the store
+func (e *Encryption) CheckKey(key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+	check, err := open(aead, e.Check, []byte(encryptionFile))
+	if err != nil || !bytes.Equal(check, encryptionCheck) {
+		return ErrWrongPassphrase
+	}
+	return nil
+}
+
+func (e *Encryption) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), e.Salt, e.N, e.R, e.P, keyLength)
+}
+
+// Remove the encryption parameters from dir, the store is plaintext from then on
+func RemoveEncryption(dir string) error {
+	return os.Remove(filepath.Join(dir, encryptionFile))
+}
+
+// Store encrypting the values of store with key
+func NewEncryptedStore(store Store, key []byte) (*EncryptedStore, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedStore{Store: store, aead: aead}, nil
+}
+
+// Whether data is a value written by an EncryptedStore
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedPrefix)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	sealed := append([]byte{}, encryptedPrefix...)
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if !IsEncrypted(sealed) {
+		return nil, fmt.Errorf("value is not encrypted, run tokendokey migrate")
+	}
+	sealed = sealed[len(encryptedPrefix):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted value is truncated")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+func additionalData(client, key string) []byte {
+	return []byte(client + "\x00" + key)
+}
+
+func (s *EncryptedStore) Get(client, key string) ([]byte, error) {
+	sealed, err := s.Store.Get(client, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := open(s.aead, sealed, additionalData(client, key))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s of %s: %w", key, client, err)
+	}
+	return data, nil
+}
+
+func (s *EncryptedStore) Put(client, key string, data []byte) error {
+	sealed, err := seal(s.aead, data, additionalData(client, key))
+	if err != nil {
+		return err
+	}
+	return s.Store.Put(client, key, sealed)
+}
+
+func (s *EncryptedStore) Remove(client, key string) error {
+	return s.Store.Remove(client, key)
+}
+
+func (s *EncryptedStore) Keys(client string) ([]string, error) {
+	return s.Store.Keys(client)
+}
+
+func (s *EncryptedStore) List() ([]string, error) {
+	return s.Store.List()
+}
+
+func (s *EncryptedStore) Delete(client string) error {
+	return s.Store.Delete(client)
+}
+
+// Lock the client in the underlying store, when it supports locking
+func (s *EncryptedStore) Lock(client string) (func(), error) {
+	if locker, ok := s.Store.(Locker); ok {
+		return locker.Lock(client)
+	}
+	return func() {}, nil
+}
@@ -0,0 +1,104 @@
+package tokendokey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+var (
+	// No usable refresh token is cached, the user has to login again
+	ErrLoginRequired = errors.New("refresh token is invalid, please login again")
+	// The user denied the device authorization request
+	ErrAccessDenied = errors.New("the authorization request was denied")
+	// The device code expired before the user finished the authorization
+	ErrExpiredToken = errors.New("the device code expired, please login again")
+	// The server detected the reuse of a rotated refresh token, it usually revokes the whole session then
+	ErrRefreshTokenReused = errors.New("the refresh token was already used, the session may have been revoked, please login again")
+	// The store is encrypted and no passphrase is available
+	ErrStoreLocked = errors.New("the token store is encrypted, set TOKENDOKEY_PASSPHRASE or start the agent")
+	// The passphrase does not decrypt the store
+	ErrWrongPassphrase = errors.New("wrong passphrase for the token store")
+	// The token is not signed by the issuer of the client or its claims do not match the client
+	ErrInvalidToken = errors.New("token verification failed")
+)
+
+// Error response of the authorization server, RFC 6749 section 5.2
+type OAuthError struct {
+	// HTTP status of the response, 0 for errors returned in the authorization redirect
+	StatusCode  int
+	Code        string
+	Description string
+	// Page describing the error, error_uri of the response
+	URI string
+}
+
+func (e *OAuthError) Error() string {
+	var message string
+	switch {
+	case e.Code == "" && e.StatusCode != 0:
+		message = fmt.Sprintf("unexpected response status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	case e.Code == "":
+		message = "unexpected response"
+	case e.Description == "":
+		message = e.Code
+	default:
+		message = e.Code + ": " + e.Description
+	}
+	if e.URI != "" {
+		message += " (see " + e.URI + ")"
+	}
+	return message
+}
+
+// Error of a response body of another endpoint than the token endpoint, e.g. revocation
+func responseError(statusCode int, body []byte) *OAuthError {
+	var response struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+		ErrorURI         string `json:"error_uri"`
+	}
+	json.Unmarshal(body, &response)
+	return &OAuthError{StatusCode: statusCode, Code: response.Error, Description: response.ErrorDescription, URI: response.ErrorURI}
+}
+
+// Whether the server failed rather than refused the request, retrying later may succeed
+func (e *OAuthError) ServerError() bool {
+	switch e.Code {
+	case "server_error", "temporarily_unavailable":
+		return true
+	case "":
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// Whether the server does not accept the client itself, its configuration needs fixing.
+// Servers failing the client authentication answer 401, some without an error code.
+func (e *OAuthError) ClientError() bool {
+	switch e.Code {
+	case "invalid_client", "unauthorized_client":
+		return true
+	case "":
+		return e.StatusCode == http.StatusUnauthorized
+	}
+	return false
+}
+
+// Error descriptions of servers detecting refresh token reuse, e.g. Keycloak's
+// "Maximum allowed refresh token reuse exceeded"
+var refreshTokenReuse = regexp.MustCompile(`(?i)reuse|replay|(already|been) used`)
+
+// Classify the error of a refresh token grant: a rejected refresh token requires a new login
+func refreshError(err error) error {
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		return err
+	}
+	if refreshTokenReuse.MatchString(oauthErr.Description) {
+		return fmt.Errorf("%w (%w)", ErrRefreshTokenReused, err)
+	}
+	return fmt.Errorf("%w (%w)", ErrLoginRequired, err)
+}
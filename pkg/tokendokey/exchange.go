@@ -0,0 +1,226 @@
+package tokendokey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	tokenExchangeGrant   = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenTokenType = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// Key prefix of the tokens exchanged by a client
+const exchangeDir = "exchange"
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type ExchangeOptions struct {
+	Audience string
+	Scope    string
+	// Client whose access token is sent as actor_token, none when empty
+	ActorClient string
+	// Exchange again even if the cached token is still valid
+	Force bool
+}
+
+// Exchange the access token of this client for a token issued to another audience (RFC 8693).
+// The result is cached per audience, scope and actor below the exchange key of this client.
+func (c *Client) Exchange(ctx context.Context, options ExchangeOptions) (string, error) {
+	if options.Audience == "" {
+		return "", fmt.Errorf("audience is required")
+	}
+
+	exchangeKey := exchangeCacheKey(options)
+	accessTokenKey := path.Join(exchangeKey, accessTokenFile)
+	metadataKey := path.Join(exchangeKey, metadataFile)
+
+	accessToken := c.readFile(accessTokenKey)
+	if !options.Force && accessToken != "" {
+		expiry, ok := renewalExpiry(c.readMetadata(metadataKey), accessToken)
+		if ok && expiresAfter(expiry, "access") {
+			return accessToken, nil
+		}
+	}
+
+	subjectToken, err := c.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting subject token from %s: %w", c.Name, err)
+	}
+	var actorToken string
+	switch options.ActorClient {
+	case "":
+	case c.Name:
+		actorToken = subjectToken
+	default:
+		actorToken, err = c.tokenOf(ctx, options.ActorClient)
+		if err != nil {
+			return "", fmt.Errorf("getting actor token from %s: %w", options.ActorClient, err)
+		}
+	}
+	exchangeReq := tokenExchangeForm(subjectToken, actorToken, options.Audience, options.Scope)
+	tokens, err := c.requestToken(ctx, c.httpClient(), c.Config.TokenIssueURL, exchangeReq)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.writeFile(accessTokenKey, tokens.AccessToken)
+	if err != nil {
+		return "", err
+	}
+	err = c.writeMetadata(metadataKey, newTokenMetadata(tokens, "", nil))
+	if err != nil {
+		return "", err
+	}
+	return tokens.AccessToken, nil
+}
+
+// Remove the cached exchanged tokens, they were issued on behalf of the session that just ended
+func (c *Client) clearExchangedTokens() error {
+	keys, err := c.Store.Keys(c.Name)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, key := range keys {
+		if strings.HasPrefix(key, exchangeDir+"/") {
+			errs = append(errs, c.Store.Remove(c.Name, key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Whether the client has cached tokens from an exchange
+func (c *Client) HasExchangedTokens() bool {
+	keys, _ := c.exchangedTokenKeys()
+	return len(keys) > 0
+}
+
+// Keys of the cached exchanged access tokens of the client
+func (c *Client) exchangedTokenKeys() ([]string, error) {
+	keys, err := c.Store.Keys(c.Name)
+	if err != nil {
+		return nil, err
+	}
+	var tokenKeys []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, exchangeDir+"/") && path.Base(key) == accessTokenFile {
+			tokenKeys = append(tokenKeys, key)
+		}
+	}
+	return tokenKeys, nil
+}
+
+// Key below which the token of an exchange is cached. The readable audience is followed by a hash
+// of the request, tokens differing in scope or actor must not replace each other.
+func exchangeCacheKey(options ExchangeOptions) string {
+	sum := sha256.Sum256([]byte(options.Audience + "\x00" + options.Scope + "\x00" + options.ActorClient))
+	name := unsafePathChars.ReplaceAllString(options.Audience, "_") + "-" + hex.EncodeToString(sum[:6])
+	return path.Join(exchangeDir, name)
+}
+
+// Build the token exchange request, without actor token when actorToken is empty
+func tokenExchangeForm(subjectToken, actorToken, audience, scope string) url.Values {
+	exchangeReq := url.Values{
+		"grant_type":           {tokenExchangeGrant},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {accessTokenTokenType},
+		"requested_token_type": {accessTokenTokenType},
+	}
+	if actorToken != "" {
+		exchangeReq.Set("actor_token", actorToken)
+		exchangeReq.Set("actor_token_type", accessTokenTokenType)
+	}
+	if audience != "" {
+		exchangeReq.Set("audience", audience)
+	}
+	if scope != "" {
+		exchangeReq.Set("scope", scope)
+	}
+	return exchangeReq
+}
+
+// Build the token request of a token_exchange client from the tokens of its subject and actor client
+func (c *Client) exchangeGrantForm(ctx context.Context) (url.Values, error) {
+	config := c.Config
+	err := ValidateExchange(c.Store, c.Name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	subjectToken, err := c.tokenOf(ctx, config.SubjectClient)
+	if err != nil {
+		return nil, fmt.Errorf("getting subject token from %s: %w", config.SubjectClient, err)
+	}
+	var actorToken string
+	if config.ActorClient != "" {
+		actorToken, err = c.tokenOf(ctx, config.ActorClient)
+		if err != nil {
+			return nil, fmt.Errorf("getting actor token from %s: %w", config.ActorClient, err)
+		}
+	}
+	return tokenExchangeForm(subjectToken, actorToken, config.Audience, config.Scope), nil
+}
+
+// Check that the subject and actor clients of a token_exchange client, and theirs in turn, never lead
+// back to it. Each client holds its lock while it gets the tokens of the others, a cycle waits forever.
+func ValidateExchange(store Store, name string, config Config) error {
+	if config.GrantType == GrantTokenExchange && config.SubjectClient == "" {
+		return fmt.Errorf("token_exchange client %s has no subject client", name)
+	}
+	return checkExchangeChain(store, config, []string{name})
+}
+
+func checkExchangeChain(store Store, config Config, chain []string) error {
+	if config.GrantType != GrantTokenExchange {
+		return nil
+	}
+	for _, dependency := range []string{config.SubjectClient, config.ActorClient} {
+		if dependency == "" {
+			continue
+		}
+		// Full slice expression, sibling branches must not share the appended element
+		next := append(chain[:len(chain):len(chain)], dependency)
+		for _, visited := range chain {
+			if visited == dependency {
+				return fmt.Errorf("token exchange clients form a cycle: %s", strings.Join(next, " -> "))
+			}
+		}
+
+		other, err := LoadFrom(store, dependency)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Reported when its token is needed, it may be created after this client
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = checkExchangeChain(store, other.Config, next)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Access token of another client sharing the same HTTP client. Never this client itself,
+// callers may hold its lock.
+func (c *Client) tokenOf(ctx context.Context, clientName string) (string, error) {
+	if clientName == c.Name {
+		return "", fmt.Errorf("client %s cannot use its own token for token exchange", c.Name)
+	}
+	other, err := LoadFrom(c.Store, clientName)
+	if err != nil {
+		return "", err
+	}
+	other.HTTPClient = c.HTTPClient
+	return other.Token(ctx)
+}
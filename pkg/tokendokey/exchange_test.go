@@ -0,0 +1,169 @@
+package tokendokey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Token endpoint logging in user-access-<n> on the nth device code login and exchanging a
+// subject token for exchanged-for-<subject token>
+func newExchangeServer(t *testing.T) *httptest.Server {
+	logins := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		writeTokenResponse(w, http.StatusOK, map[string]interface{}{"device_code": "device", "user_code": "USER", "verification_uri": "https://idp.example.com/device"})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch grantType := r.PostForm.Get("grant_type"); grantType {
+		case "urn:ietf:params:oauth:grant-type:device_code":
+			logins++
+			writeTokenResponse(w, http.StatusOK, map[string]interface{}{
+				"access_token":  fmt.Sprintf("user-access-%d", logins),
+				"refresh_token": fmt.Sprintf("user-refresh-%d", logins),
+				"expires_in":    300,
+			})
+		case tokenExchangeGrant:
+			token := "exchanged-for-" + r.PostForm.Get("subject_token")
+			if actor := r.PostForm.Get("actor_token"); actor != "" {
+				token += "-by-" + actor
+			}
+			if scope := r.PostForm.Get("scope"); scope != "" {
+				token += "-with-" + scope
+			}
+			writeTokenResponse(w, http.StatusOK, map[string]interface{}{"access_token": token, "expires_in": 300})
+		default:
+			t.Errorf("unexpected grant_type %q", grantType)
+			writeTokenResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "unsupported_grant_type"})
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLoginClearsExchangedTokens(t *testing.T) {
+	server := newExchangeServer(t)
+	client := newTestClient(t, "user", Config{ClientID: "user", TokenIssueURL: server.URL + "/token", DeviceCodeURL: server.URL + "/device"})
+	ctx := context.Background()
+
+	if err := client.Login(ctx, testPrompter{}, LoginOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := client.Exchange(ctx, ExchangeOptions{Audience: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "exchanged-for-user-access-1" {
+		t.Fatalf("Exchange = %q, want %q", token, "exchanged-for-user-access-1")
+	}
+
+	// Another user logs in, the token exchanged for the first one must not be returned
+	if err := client.Login(ctx, testPrompter{}, LoginOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	token, err = client.Exchange(ctx, ExchangeOptions{Audience: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "exchanged-for-user-access-2" {
+		t.Errorf("Exchange after a new login = %q, want %q", token, "exchanged-for-user-access-2")
+	}
+}
+
+// Tokens exchanged for another scope or actor are cached apart from each other and reused while valid
+func TestExchangeCache(t *testing.T) {
+	server := newExchangeServer(t)
+	store := &FileStore{Dir: t.TempDir()}
+	client := addTestClient(t, store, "user", Config{ClientID: "user", TokenIssueURL: server.URL + "/token", DeviceCodeURL: server.URL + "/device"})
+	// Actor client with a cached token, the server issues no client_credentials tokens
+	service := addTestClient(t, store, "service", Config{ClientID: "service", ClientSecret: "secret", TokenIssueURL: server.URL + "/token", GrantType: GrantClientCredentials})
+	if err := service.saveLoginTokens(&tokenResponse{AccessToken: "service-access", ExpiresIn: 300}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := client.Login(ctx, testPrompter{}, LoginOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		options ExchangeOptions
+		want    string
+	}{
+		{ExchangeOptions{Audience: "api"}, "exchanged-for-user-access-1"},
+		{ExchangeOptions{Audience: "api", Scope: "read"}, "exchanged-for-user-access-1-with-read"},
+		{ExchangeOptions{Audience: "api", ActorClient: "user"}, "exchanged-for-user-access-1-by-user-access-1"},
+		{ExchangeOptions{Audience: "api", ActorClient: "service"}, "exchanged-for-user-access-1-by-service-access"},
+	}
+	for _, test := range tests {
+		token, err := client.Exchange(ctx, test.options)
+		if err != nil {
+			t.Fatalf("Exchange(%+v): %v", test.options, err)
+		}
+		if token != test.want {
+			t.Errorf("Exchange(%+v) = %q, want %q", test.options, token, test.want)
+		}
+	}
+
+	// Cached tokens need no request
+	server.Close()
+	for _, test := range tests {
+		token, err := client.Exchange(ctx, test.options)
+		if err != nil {
+			t.Fatalf("cached Exchange(%+v): %v", test.options, err)
+		}
+		if token != test.want {
+			t.Errorf("cached Exchange(%+v) = %q, want %q", test.options, token, test.want)
+		}
+	}
+	if _, err := client.Exchange(ctx, ExchangeOptions{Audience: "api", Force: true}); err == nil {
+		t.Error("forced Exchange used the cached token")
+	}
+}
+
+// A token_exchange client gets its token from the tokens of its subject and actor clients
+func TestTokenExchangeClient(t *testing.T) {
+	server := newExchangeServer(t)
+	store := &FileStore{Dir: t.TempDir()}
+	user := addTestClient(t, store, "user", Config{ClientID: "user", TokenIssueURL: server.URL + "/token", DeviceCodeURL: server.URL + "/device"})
+	api := addTestClient(t, store, "api", Config{ClientID: "api", TokenIssueURL: server.URL + "/token", GrantType: GrantTokenExchange, SubjectClient: "user", ActorClient: "user", Audience: "api", Scope: "read"})
+
+	if _, err := api.Token(context.Background()); !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("Token before the subject logged in = %v, want ErrLoginRequired", err)
+	}
+	if err := user.Login(context.Background(), testPrompter{}, LoginOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := api.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "exchanged-for-user-access-1-by-user-access-1-with-read"; token != want {
+		t.Errorf("Token = %q, want %q", token, want)
+	}
+}
+
+// Clients exchanging each other's tokens would wait for each other's lock forever
+func TestTokenExchangeCycle(t *testing.T) {
+	server := newExchangeServer(t)
+	store := &FileStore{Dir: t.TempDir()}
+	first := addTestClient(t, store, "first", Config{ClientID: "first", TokenIssueURL: server.URL + "/token", GrantType: GrantTokenExchange, SubjectClient: "second"})
+	addTestClient(t, store, "second", Config{ClientID: "second", TokenIssueURL: server.URL + "/token", GrantType: GrantTokenExchange, SubjectClient: "third"})
+	addTestClient(t, store, "third", Config{ClientID: "third", TokenIssueURL: server.URL + "/token", GrantType: GrantTokenExchange, SubjectClient: "user", ActorClient: "first"})
+
+	err := ValidateExchange(store, "first", first.Config)
+	if err == nil || !strings.Contains(err.Error(), "first -> second -> third -> first") {
+		t.Errorf("ValidateExchange = %v, want the cycle first -> second -> third -> first", err)
+	}
+	if _, err := first.Token(context.Background()); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Token = %v, want a cycle error", err)
+	}
+	if err := ValidateExchange(store, "self", Config{GrantType: GrantTokenExchange, SubjectClient: "self"}); err == nil {
+		t.Error("ValidateExchange accepted a client exchanging its own token")
+	}
+}
@@ -0,0 +1,112 @@
+package tokendokey
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Ask the introspection endpoint whether the server considers token active, RFC 7662.
+// tokenTypeHint is access_token or refresh_token. Inactive tokens are no error, see "active".
+func (c *Client) Introspect(ctx context.Context, token, tokenTypeHint string) (map[string]interface{}, error) {
+	if c.Config.IntrospectionURL == "" {
+		return nil, fmt.Errorf("client %s has no introspection_endpoint, initialize it again to fetch it from the discovery document", c.Name)
+	}
+	form := url.Values{"token": {token}}
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+
+	resp, err := c.postForm(ctx, c.httpClient(), c.Config.IntrospectionURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp.StatusCode, body)
+	}
+
+	var introspection map[string]interface{}
+	err = json.Unmarshal(body, &introspection)
+	if err != nil {
+		return nil, fmt.Errorf("parsing introspection response: %w", err)
+	}
+	if _, ok := introspection["active"].(bool); !ok {
+		return nil, fmt.Errorf("introspection response has no active member")
+	}
+	return introspection, nil
+}
+
+// Claims about the user of the client's access token from the userinfo endpoint, OpenID Connect
+// Core section 5.3. The token is refreshed when needed, signed JWT responses are returned unverified.
+func (c *Client) UserInfo(ctx context.Context) (map[string]interface{}, error) {
+	if c.Config.UserInfoURL == "" {
+		return nil, fmt.Errorf("client %s has no userinfo_endpoint, initialize it again to fetch it from the discovery document", c.Name)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.Config.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// A copy keeps the timeout, redirect policy and cookies of the configured client
+	httpClient := *c.httpClient()
+	httpClient.Transport = &Transport{Client: c, Base: httpClient.Transport}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, bearerError(resp, body)
+	}
+
+	claims := map[string]interface{}{}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/jwt" {
+		_, _, err = new(jwt.Parser).ParseUnverified(strings.TrimSpace(string(body)), jwt.MapClaims(claims))
+	} else {
+		err = json.Unmarshal(body, &claims)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing userinfo response: %w", err)
+	}
+	return claims, nil
+}
+
+var bearerErrorParam = regexp.MustCompile(`(error|error_description|error_uri)="([^"]*)"`)
+
+// Error of a resource server, from the WWW-Authenticate header of RFC 6750 section 3 or the body
+func bearerError(resp *http.Response, body []byte) *OAuthError {
+	oauthErr := responseError(resp.StatusCode, body)
+	if oauthErr.Code != "" {
+		return oauthErr
+	}
+	for _, param := range bearerErrorParam.FindAllStringSubmatch(resp.Header.Get("WWW-Authenticate"), -1) {
+		switch param[1] {
+		case "error":
+			oauthErr.Code = param[2]
+		case "error_description":
+			oauthErr.Description = param[2]
+		case "error_uri":
+			oauthErr.URI = param[2]
+		}
+	}
+	return oauthErr
+}
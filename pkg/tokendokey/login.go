@@ -0,0 +1,318 @@
+package tokendokey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Login flows
+const (
+	FlowDevice   = "device"
+	FlowAuthCode = "authcode"
+)
+
+// Default and slow_down polling intervals from RFC 8628 section 3.5, variables so tests can poll faster
+var (
+	defaultPollInterval = 5 * time.Second
+	slowDownIncrement   = 5 * time.Second
+)
+
+// How long to wait for the browser to come back to the loopback listener
+const authCodeTimeout = 5 * time.Minute
+
+// Shows the user where to authorize a login
+type Prompter interface {
+	// Ask the user to open verificationURL, userCode is empty when the URL already carries it
+	Authorize(ctx context.Context, verificationURL, userCode string) error
+}
+
+type LoginOptions struct {
+	// FlowDevice when empty
+	Flow string
+	// Request an offline token instead of a regular refresh token
+	OfflineToken bool
+	// Port of the loopback redirect listener of the authcode flow, random when 0
+	Port int
+}
+
+// Device authorization response, RFC 8628 section 3.2
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+	Error                   string `json:"error"`
+	ErrorDescription        string `json:"error_description"`
+	ErrorURI                string `json:"error_uri"`
+}
+
+type authCodeResult struct {
+	code string
+	err  error
+}
+
+// Generate a cryptographically secure random string of n bytes, base64 URL encoded
+func randomString(n int) (string, error) {
+	data := make([]byte, n)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Generate a cryptographically secure random string as the code verifier
+func generateCodeVerifier() (string, error) {
+	return randomString(43)
+}
+
+// Hash the code verifier using SHA-256 and base64 URL encode it to create the code challenge
+func generateCodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// Login the user and cache the access and refresh tokens
+func (c *Client) Login(ctx context.Context, prompter Prompter, options LoginOptions) error {
+	var tokens *tokenResponse
+	var err error
+	switch options.Flow {
+	case "", FlowDevice:
+		tokens, err = c.loginDeviceCode(ctx, prompter, options)
+	case FlowAuthCode:
+		tokens, err = c.loginAuthCode(ctx, prompter, options)
+	default:
+		return fmt.Errorf("unsupported flow: %s", options.Flow)
+	}
+	if err != nil {
+		return err
+	}
+	return c.saveLoginTokens(tokens)
+}
+
+// Store the tokens of a new login. Tokens exchanged for the previous session, possibly of another user, are removed.
+// The client is locked like for a refresh, a concurrent refresh must not overwrite the new refresh token.
+func (c *Client) saveLoginTokens(tokens *tokenResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = c.saveTokens(tokens)
+	if err != nil {
+		return err
+	}
+	return c.clearExchangedTokens()
+}
+
+// Login using Device Code flow, polling the token endpoint as described in RFC 8628
+func (c *Client) loginDeviceCode(ctx context.Context, prompter Prompter, options LoginOptions) (*tokenResponse, error) {
+	verifier, err := generateCodeVerifier()
+	if err != nil {
+		return nil, fmt.Errorf("generating code verifier: %w", err)
+	}
+
+	deviceCodeReq := url.Values{
+		"code_challenge":        {generateCodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	if options.OfflineToken {
+		deviceCodeReq.Add("scope", "email profile offline_access")
+	}
+
+	deviceCodeResp, err := c.postForm(ctx, c.httpClient(), c.Config.DeviceCodeURL, deviceCodeReq)
+	if err != nil {
+		return nil, fmt.Errorf("requesting device code: %w", err)
+	}
+	defer deviceCodeResp.Body.Close()
+
+	body, _ := io.ReadAll(deviceCodeResp.Body)
+	var deviceAuth deviceAuthorizationResponse
+	json.Unmarshal(body, &deviceAuth)
+
+	if deviceAuth.Error != "" || deviceAuth.DeviceCode == "" {
+		return nil, fmt.Errorf("requesting device code: %w", &OAuthError{StatusCode: deviceCodeResp.StatusCode, Code: deviceAuth.Error, Description: deviceAuth.ErrorDescription, URI: deviceAuth.ErrorURI})
+	}
+
+	if deviceAuth.VerificationURIComplete != "" {
+		err = prompter.Authorize(ctx, deviceAuth.VerificationURIComplete, "")
+	} else {
+		err = prompter.Authorize(ctx, deviceAuth.VerificationURI, deviceAuth.UserCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	interval := defaultPollInterval
+	if deviceAuth.Interval > 0 {
+		interval = time.Duration(deviceAuth.Interval) * time.Second
+	}
+	var deadline time.Time
+	if deviceAuth.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(deviceAuth.ExpiresIn) * time.Second)
+	}
+
+	pollReq := url.Values{
+		"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code":   {deviceAuth.DeviceCode},
+		"code_verifier": {verifier},
+	}
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, ErrExpiredToken
+		}
+
+		tokens, err := c.requestToken(ctx, c.httpClient(), c.Config.TokenIssueURL, pollReq)
+		if err == nil {
+			return tokens, nil
+		}
+
+		var oauthErr *OAuthError
+		if !errors.As(err, &oauthErr) {
+			return nil, err
+		}
+		switch oauthErr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownIncrement
+		case "access_denied":
+			return nil, ErrAccessDenied
+		case "expired_token":
+			return nil, ErrExpiredToken
+		default:
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Login using Authorization Code flow with PKCE, receiving the code on a loopback listener
+func (c *Client) loginAuthCode(ctx context.Context, prompter Prompter, options LoginOptions) (*tokenResponse, error) {
+	if c.Config.AuthorizationURL == "" {
+		return nil, fmt.Errorf("authorization_endpoint is not configured for this client, please run init again")
+	}
+
+	verifier, err := generateCodeVerifier()
+	if err != nil {
+		return nil, fmt.Errorf("generating code verifier: %w", err)
+	}
+	state, err := randomString(24)
+	if err != nil {
+		return nil, fmt.Errorf("generating state: %w", err)
+	}
+
+	// Port 0 lets the OS pick a free port
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", options.Port))
+	if err != nil {
+		return nil, fmt.Errorf("starting redirect listener: %w", err)
+	}
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/callback", listener.Addr().(*net.TCPAddr).Port)
+
+	results := make(chan authCodeResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		// Requests without the state of this login, e.g. a stale browser tab or another local process,
+		// are refused without ending the login
+		if query.Get("state") != state {
+			http.Error(w, "Login failed: state mismatch in authorization response", http.StatusBadRequest)
+			return
+		}
+		var result authCodeResult
+		switch {
+		case query.Get("error") != "":
+			result.err = fmt.Errorf("authorization failed: %w", &OAuthError{Code: query.Get("error"), Description: query.Get("error_description"), URI: query.Get("error_uri")})
+		case query.Get("code") == "":
+			result.err = fmt.Errorf("authorization response does not contain a code")
+		default:
+			result.code = query.Get("code")
+		}
+
+		if result.err != nil {
+			http.Error(w, "Login failed: "+result.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Login finished, you can close this window and return to tokendokey.")
+		}
+
+		select {
+		case results <- result:
+		default:
+		}
+	})
+
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	authReq := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.Config.ClientID},
+		"redirect_uri":          {redirectURI},
+		"state":                 {state},
+		"code_challenge":        {generateCodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	if options.OfflineToken {
+		authReq.Set("scope", "openid email profile offline_access")
+	} else {
+		authReq.Set("scope", "openid")
+	}
+
+	authURL := c.Config.AuthorizationURL
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + authReq.Encode()
+	} else {
+		authURL += "?" + authReq.Encode()
+	}
+
+	err = prompter.Authorize(ctx, authURL, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var result authCodeResult
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(authCodeTimeout):
+		return nil, fmt.Errorf("timed out waiting for the authorization response")
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	// Exchange the authorization code for tokens
+	tokenReq := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {result.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	}
+	tokens, err := c.requestToken(ctx, c.httpClient(), c.Config.TokenIssueURL, tokenReq)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging authorization code: %w", err)
+	}
+	return tokens, nil
+}
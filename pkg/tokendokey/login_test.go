@@ -0,0 +1,115 @@
+package tokendokey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Poll faster than RFC 8628 asks for until the test ends
+func shortenPollIntervals(t *testing.T) {
+	pollInterval, increment := defaultPollInterval, slowDownIncrement
+	defaultPollInterval, slowDownIncrement = 50*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() {
+		defaultPollInterval, slowDownIncrement = pollInterval, increment
+	})
+}
+
+// Device authorization endpoint at /device and a token endpoint at /token answering the nth poll with
+// the nth of responses, the last one repeated. Returns the times of the polls.
+func newDeviceCodeServer(t *testing.T, expiresIn int, responses ...map[string]interface{}) (*httptest.Server, func() []time.Time) {
+	var mu sync.Mutex
+	var polls []time.Time
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]interface{}{"device_code": "device", "user_code": "USER", "verification_uri": "https://idp.example.com/device"}
+		if expiresIn > 0 {
+			response["expires_in"] = expiresIn
+		}
+		writeTokenResponse(w, http.StatusOK, response)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("device_code") != "device" {
+			t.Errorf("device_code = %q, want %q", r.PostForm.Get("device_code"), "device")
+		}
+		mu.Lock()
+		polls = append(polls, time.Now())
+		response := responses[min(len(polls), len(responses))-1]
+		mu.Unlock()
+
+		status := http.StatusOK
+		if response["error"] != nil {
+			status = http.StatusBadRequest
+		}
+		writeTokenResponse(w, status, response)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), polls...)
+	}
+}
+
+func TestDeviceCodePolling(t *testing.T) {
+	shortenPollIntervals(t)
+	server, polls := newDeviceCodeServer(t, 0,
+		map[string]interface{}{"error": "authorization_pending"},
+		map[string]interface{}{"error": "slow_down"},
+		map[string]interface{}{"access_token": "access-1", "refresh_token": "refresh-1", "expires_in": 300},
+	)
+	client := newTestClient(t, "user", Config{ClientID: "user", TokenIssueURL: server.URL + "/token", DeviceCodeURL: server.URL + "/device"})
+
+	if err := client.Login(context.Background(), testPrompter{}, LoginOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.RefreshToken(); got != "refresh-1" {
+		t.Errorf("RefreshToken = %q, want %q", got, "refresh-1")
+	}
+
+	times := polls()
+	if len(times) != 3 {
+		t.Fatalf("token endpoint polled %d times, want 3", len(times))
+	}
+	// authorization_pending keeps the interval, slow_down increases it for all following polls
+	if gap := times[1].Sub(times[0]); gap < defaultPollInterval {
+		t.Errorf("poll after authorization_pending came after %s, want at least %s", gap, defaultPollInterval)
+	}
+	if gap, want := times[2].Sub(times[1]), defaultPollInterval+slowDownIncrement; gap < want {
+		t.Errorf("poll after slow_down came after %s, want at least %s", gap, want)
+	}
+}
+
+func TestDeviceCodePollingErrors(t *testing.T) {
+	shortenPollIntervals(t)
+	for _, test := range []struct {
+		name      string
+		expiresIn int
+		response  map[string]interface{}
+		want      error
+	}{
+		{"access_denied", 0, map[string]interface{}{"error": "access_denied"}, ErrAccessDenied},
+		{"expired_token", 0, map[string]interface{}{"error": "expired_token"}, ErrExpiredToken},
+		// The device code expires while the user has not authorized yet
+		{"expires_in", 1, map[string]interface{}{"error": "authorization_pending"}, ErrExpiredToken},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newDeviceCodeServer(t, test.expiresIn, test.response)
+			client := newTestClient(t, "user", Config{ClientID: "user", TokenIssueURL: server.URL + "/token", DeviceCodeURL: server.URL + "/device"})
+
+			err := client.Login(context.Background(), testPrompter{}, LoginOptions{})
+			if !errors.Is(err, test.want) {
+				t.Errorf("Login = %v, want %v", err, test.want)
+			}
+			if got := client.RefreshToken(); got != "" {
+				t.Errorf("refresh token stored after a failed login: %q", got)
+			}
+		})
+	}
+}
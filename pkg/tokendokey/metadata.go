@@ -0,0 +1,198 @@
+package tokendokey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const metadataFile = "metadata.json"
+
+// Lifetime assumed for access tokens whose expiry neither the token response nor the token tells,
+// counted from the recorded issue time. Such tokens are renewed instead of being used forever.
+const DefaultAccessTokenLifetime = 5 * time.Minute
+
+// Details of the token response the cached tokens came from, kept in metadata.json next to them.
+// Opaque tokens carry no expiry themselves, the metadata is the only place it is known.
+type TokenMetadata struct {
+	TokenType        string    `json:"token_type,omitempty"`
+	Scope            string    `json:"scope,omitempty"`
+	IssuedAt         time.Time `json:"issued_at"`
+	ExpiresIn        int       `json:"expires_in,omitempty"`
+	RefreshExpiresIn int       `json:"refresh_expires_in,omitempty"`
+	IDToken          string    `json:"id_token,omitempty"`
+	// When the refresh token was issued, earlier than IssuedAt when the server did not return a new one
+	RefreshTokenIssuedAt time.Time `json:"refresh_token_issued_at"`
+	// Whether the last response replaced the refresh token
+	RefreshTokenRotated bool `json:"refresh_token_rotated,omitempty"`
+	// Fingerprints of the tokens described, metadata of replaced tokens is ignored
+	AccessTokenSHA256  string `json:"access_token_sha256,omitempty"`
+	RefreshTokenSHA256 string `json:"refresh_token_sha256,omitempty"`
+}
+
+func tokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Metadata of a token response. refreshToken is the cached refresh token afterwards, when the
+// response had none its expiry is taken over from the previous metadata.
+func newTokenMetadata(tokens *tokenResponse, refreshToken string, previous *TokenMetadata) *TokenMetadata {
+	metadata := &TokenMetadata{
+		TokenType:          tokens.TokenType,
+		Scope:              tokens.Scope,
+		IssuedAt:           time.Now().UTC().Truncate(time.Second),
+		ExpiresIn:          tokens.ExpiresIn,
+		RefreshExpiresIn:   tokens.RefreshExpiresIn,
+		IDToken:            tokens.IDToken,
+		AccessTokenSHA256:  tokenFingerprint(tokens.AccessToken),
+		RefreshTokenSHA256: tokenFingerprint(refreshToken),
+	}
+	if refreshToken == "" {
+		return metadata
+	}
+
+	metadata.RefreshTokenIssuedAt = metadata.IssuedAt
+	if tokens.RefreshToken == "" && previous != nil && previous.RefreshTokenSHA256 == metadata.RefreshTokenSHA256 {
+		metadata.RefreshTokenIssuedAt = previous.refreshTokenIssuedAt()
+		metadata.RefreshExpiresIn = previous.RefreshExpiresIn
+	}
+	return metadata
+}
+
+func (m *TokenMetadata) refreshTokenIssuedAt() time.Time {
+	if m.RefreshTokenIssuedAt.IsZero() {
+		return m.IssuedAt
+	}
+	return m.RefreshTokenIssuedAt
+}
+
+// Expiry of the access token from expires_in, false when the response had none
+func (m *TokenMetadata) AccessTokenExpiry() (time.Time, bool) {
+	if m.ExpiresIn <= 0 {
+		return time.Time{}, false
+	}
+	return m.IssuedAt.Add(time.Duration(m.ExpiresIn) * time.Second), true
+}
+
+// Expiry of the refresh token from refresh_expires_in, false when the response had none,
+// e.g. for offline tokens
+func (m *TokenMetadata) RefreshTokenExpiry() (time.Time, bool) {
+	if m.RefreshExpiresIn <= 0 {
+		return time.Time{}, false
+	}
+	return m.refreshTokenIssuedAt().Add(time.Duration(m.RefreshExpiresIn) * time.Second), true
+}
+
+// Metadata stored under key, nil when there is none
+func (c *Client) readMetadata(key string) *TokenMetadata {
+	data, err := c.Store.Get(c.Name, key)
+	if err != nil {
+		return nil
+	}
+	var metadata TokenMetadata
+	if json.Unmarshal(data, &metadata) != nil {
+		return nil
+	}
+	return &metadata
+}
+
+func (c *Client) writeMetadata(key string, metadata *TokenMetadata) error {
+	data, _ := json.MarshalIndent(metadata, "", "  ")
+	return c.Store.Put(c.Name, key, data)
+}
+
+// Metadata of the cached tokens, nil when there is none
+func (c *Client) Metadata() *TokenMetadata {
+	return c.readMetadata(metadataFile)
+}
+
+// Expiry of accessToken from the metadata when it describes that token, else from its exp claim
+func tokenExpiry(metadata *TokenMetadata, accessToken string) (time.Time, bool) {
+	if metadata != nil && metadata.AccessTokenSHA256 == tokenFingerprint(accessToken) {
+		if expiry, ok := metadata.AccessTokenExpiry(); ok {
+			return expiry, true
+		}
+	}
+	return TokenExpiry(accessToken)
+}
+
+// Expiry after which accessToken is renewed: its expiry, or DefaultAccessTokenLifetime after it was
+// issued when that is unknown. False when the metadata does not describe the token either.
+func renewalExpiry(metadata *TokenMetadata, accessToken string) (time.Time, bool) {
+	if expiry, ok := tokenExpiry(metadata, accessToken); ok {
+		return expiry, true
+	}
+	if metadata != nil && metadata.AccessTokenSHA256 == tokenFingerprint(accessToken) && !metadata.IssuedAt.IsZero() {
+		return metadata.IssuedAt.Add(DefaultAccessTokenLifetime), true
+	}
+	return time.Time{}, false
+}
+
+// Expiry of the cached access token, false when neither the metadata nor the token tell
+func (c *Client) AccessTokenExpiry() (time.Time, bool) {
+	return tokenExpiry(c.Metadata(), c.AccessToken())
+}
+
+// Time at which Token renews the cached access token: its expiry, or DefaultAccessTokenLifetime
+// after it was issued when that is unknown. False when it is renewed right away.
+func (c *Client) AccessTokenRenewalExpiry() (time.Time, bool) {
+	return renewalExpiry(c.Metadata(), c.AccessToken())
+}
+
+// Expiry of the cached refresh token, false when neither the metadata nor the token tell
+func (c *Client) RefreshTokenExpiry() (time.Time, bool) {
+	refreshToken := c.RefreshToken()
+	if metadata := c.Metadata(); metadata != nil && metadata.RefreshTokenSHA256 == tokenFingerprint(refreshToken) {
+		if expiry, ok := metadata.RefreshTokenExpiry(); ok {
+			return expiry, true
+		}
+	}
+	return TokenExpiry(refreshToken)
+}
+
+// Why the client would not use token of tokenType, access or refresh, from its cache, nil when it would.
+// Access tokens of unknown expiry are renewed DefaultAccessTokenLifetime after they were issued, and
+// right away when their issue time was not recorded. Refresh tokens of unknown expiry are assumed valid,
+// the server rejecting them asks for a login.
+func (c *Client) TokenValidityError(token, tokenType string) error {
+	if token == "" {
+		return errors.New("token is empty")
+	}
+	var expiry time.Time
+	var ok bool
+	switch tokenType {
+	case "access":
+		expiry, ok = renewalExpiry(c.Metadata(), token)
+		if !ok {
+			return errors.New("token expiry and issue time are unknown")
+		}
+	case "refresh":
+		if token == c.RefreshToken() {
+			expiry, ok = c.RefreshTokenExpiry()
+		} else {
+			expiry, ok = TokenExpiry(token)
+		}
+	default:
+		return TokenValidityError(token, tokenType)
+	}
+	if !ok {
+		return nil
+	}
+	return expiryError(expiry, tokenType)
+}
+
+// Whether the cached access token is usable for a while longer
+func (c *Client) accessTokenValid(accessToken string) bool {
+	return c.TokenValidityError(accessToken, "access") == nil
+}
+
+// Whether the cached refresh token is usable for a while longer
+func (c *Client) refreshTokenValid() bool {
+	return c.TokenValidityError(c.RefreshToken(), "refresh") == nil
+}
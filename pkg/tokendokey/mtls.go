@@ -0,0 +1,62 @@
+package tokendokey
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Return a valid access token, refreshing it or getting a new one with mTLS Direct Grant flow.
+// Without caCertPath the server certificate is not verified.
+func (c *Client) MTLSToken(ctx context.Context, clientCertPath, clientKeyPath, caCertPath string) (string, error) {
+	accessToken := c.AccessToken()
+	if c.accessTokenValid(accessToken) {
+		return accessToken, nil
+	}
+
+	if c.refreshTokenValid() {
+		return c.Refresh(ctx)
+	}
+
+	cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+	if err != nil {
+		return "", err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if caCertPath != "" {
+		caCert, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return "", err
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+
+		tlsConfig.RootCAs = caCertPool
+	} else {
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	tr := &http.Transport{TLSClientConfig: tlsConfig}
+	client := &http.Client{Transport: tr}
+
+	directGrantReq := url.Values{
+		"grant_type": {"password"},
+	}
+
+	tokens, err := c.requestToken(ctx, client, c.Config.TokenIssueURL, directGrantReq)
+	if err != nil {
+		return "", err
+	}
+	err = c.saveLoginTokens(tokens)
+	if err != nil {
+		return "", err
+	}
+	return tokens.AccessToken, nil
+}
@@ -0,0 +1,103 @@
+package tokendokey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// The client has no revocation endpoint, its tokens can only be removed locally
+var ErrRevocationNotSupported = errors.New("the server has no revocation endpoint")
+
+// Revoke a token at the revocation endpoint, RFC 7009. tokenTypeHint is access_token or refresh_token.
+// The server answers 200 for tokens that are already invalid, so those are revoked as well.
+func (c *Client) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	if c.Config.RevocationURL == "" {
+		return ErrRevocationNotSupported
+	}
+	form := url.Values{"token": {token}}
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+
+	resp, err := c.postForm(ctx, c.httpClient(), c.Config.RevocationURL, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return responseError(resp.StatusCode, body)
+}
+
+// Revoke the cached refresh token, which ends the session at the server, then the access token and
+// the tokens it was exchanged for. Servers that only revoke refresh tokens answer unsupported_token_type
+// for access tokens, which is ignored.
+func (c *Client) RevokeTokens(ctx context.Context) error {
+	if c.Config.RevocationURL == "" {
+		return ErrRevocationNotSupported
+	}
+
+	if refreshToken := c.RefreshToken(); refreshToken != "" {
+		err := c.Revoke(ctx, refreshToken, "refresh_token")
+		if err != nil {
+			return fmt.Errorf("revoking refresh token: %w", err)
+		}
+	}
+
+	if accessToken := c.AccessToken(); accessToken != "" {
+		err := c.revokeAccessToken(ctx, accessToken)
+		if err != nil {
+			return fmt.Errorf("revoking access token: %w", err)
+		}
+	}
+
+	exchangedKeys, err := c.exchangedTokenKeys()
+	if err != nil {
+		return fmt.Errorf("listing exchanged tokens: %w", err)
+	}
+	for _, key := range exchangedKeys {
+		if token := c.readFile(key); token != "" {
+			err = c.revokeAccessToken(ctx, token)
+			if err != nil {
+				return fmt.Errorf("revoking exchanged token %s: %w", path.Dir(key), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Revoke an access token, servers not revoking access tokens count as success
+func (c *Client) revokeAccessToken(ctx context.Context, token string) error {
+	err := c.Revoke(ctx, token, "access_token")
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) && oauthErr.Code == "unsupported_token_type" {
+		return nil
+	}
+	return err
+}
+
+// URL ending the browser session of the user at the server, OpenID Connect RP-Initiated Logout.
+// Empty when the server has no end_session_endpoint.
+func (c *Client) EndSessionURL() string {
+	if c.Config.EndSessionURL == "" {
+		return ""
+	}
+	endSessionURL, err := url.Parse(c.Config.EndSessionURL)
+	if err != nil {
+		return ""
+	}
+	query := endSessionURL.Query()
+	query.Set("client_id", c.Config.ClientID)
+	if metadata := c.Metadata(); metadata != nil && metadata.IDToken != "" {
+		query.Set("id_token_hint", metadata.IDToken)
+	}
+	endSessionURL.RawQuery = query.Encode()
+	return endSessionURL.String()
+}
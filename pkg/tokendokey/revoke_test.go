@@ -0,0 +1,92 @@
+package tokendokey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// Revocation endpoint recording the revoked tokens as token:hint, answering with respond
+func newRevocationServer(t *testing.T, respond func(w http.ResponseWriter, token, hint string)) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var revoked []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		token, hint := r.PostForm.Get("token"), r.PostForm.Get("token_type_hint")
+		mu.Lock()
+		revoked = append(revoked, token+":"+hint)
+		mu.Unlock()
+		respond(w, token, hint)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(revoked)
+	}
+}
+
+// Cache a session with an exchanged token for client
+func putTestSession(t *testing.T, client *Client) {
+	t.Helper()
+	for key, token := range map[string]string{
+		refreshTokenFile: "refresh-1",
+		accessTokenFile:  "access-1",
+		path.Join(exchangeCacheKey(ExchangeOptions{Audience: "api"}), accessTokenFile): "exchanged-1",
+	} {
+		if err := client.Store.Put(client.Name, key, []byte(token)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The refresh token ends the session and is revoked first, servers refusing to revoke access tokens
+// do not fail the revocation
+func TestRevokeTokens(t *testing.T) {
+	server, revoked := newRevocationServer(t, func(w http.ResponseWriter, token, hint string) {
+		if hint == "access_token" {
+			writeTokenResponse(w, http.StatusBadRequest, map[string]interface{}{"error": "unsupported_token_type"})
+		}
+	})
+	client := newTestClient(t, "test", Config{ClientID: "test", RevocationURL: server.URL})
+	putTestSession(t, client)
+
+	if err := client.RevokeTokens(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"refresh-1:refresh_token", "access-1:access_token", "exchanged-1:access_token"}
+	if got := revoked(); !slices.Equal(got, want) {
+		t.Errorf("revoked %v, want %v", got, want)
+	}
+}
+
+// A failed revocation of the refresh token leaves the session active, nothing else is revoked
+func TestRevokeTokensFailure(t *testing.T) {
+	server, revoked := newRevocationServer(t, func(w http.ResponseWriter, token, hint string) {
+		writeTokenResponse(w, http.StatusServiceUnavailable, map[string]interface{}{"error": "temporarily_unavailable"})
+	})
+	client := newTestClient(t, "test", Config{ClientID: "test", RevocationURL: server.URL})
+	putTestSession(t, client)
+
+	err := client.RevokeTokens(context.Background())
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || !oauthErr.ServerError() {
+		t.Fatalf("RevokeTokens = %v, want a server error", err)
+	}
+	if got := revoked(); len(got) != 1 {
+		t.Errorf("revoked %v after the refresh token failed, want only the refresh token", got)
+	}
+}
+
+func TestRevokeNotSupported(t *testing.T) {
+	client := newTestClient(t, "test", Config{ClientID: "test"})
+	putTestSession(t, client)
+	if err := client.RevokeTokens(context.Background()); !errors.Is(err, ErrRevocationNotSupported) {
+		t.Errorf("RevokeTokens = %v, want ErrRevocationNotSupported", err)
+	}
+}
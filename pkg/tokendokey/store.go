@@ -0,0 +1,286 @@
+package tokendokey
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Storage of the configuration and tokens of clients. Keys are slash separated names like
+// config.json or exchange/api/access_token.txt.
+type Store interface {
+	// Data of key of the named client, an error wrapping fs.ErrNotExist when missing
+	Get(client, key string) ([]byte, error)
+	Put(client, key string, data []byte) error
+	// Remove key of the named client, missing keys are no error
+	Remove(client, key string) error
+	// Keys of the named client
+	Keys(client string) ([]string, error)
+	// Names of all clients
+	List() ([]string, error)
+	// Delete the named client with all its keys
+	Delete(client string) error
+}
+
+// Implemented by stores shared between processes, to serialize token refreshes across them
+type Locker interface {
+	// Lock the named client until unlock is called, waiting while another process holds the lock
+	Lock(client string) (unlock func(), err error)
+}
+
+// Store keeping every client in a folder below Dir and every key in a file.
+// Files are replaced atomically and clients are locked with an advisory lock on their .lock file.
+type FileStore struct {
+	Dir string
+}
+
+const lockFileName = ".lock"
+
+const (
+	// Environment variable selecting the backend of the default store
+	StoreEnv = "TOKENDOKEY_STORE"
+
+	StoreFile          = "file"
+	StoreMemory        = "memory"
+	StoreSecretService = "secret-service"
+)
+
+var (
+	defaultStoreMu sync.Mutex
+	defaultStore   Store
+)
+
+// Asked for the key of the encrypted default store, set by applications able to ask for the passphrase.
+// The default derives the key from the passphrase in TOKENDOKEY_PASSPHRASE.
+var UnlockFunc = func(encryption *Encryption) ([]byte, error) {
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		return nil, ErrStoreLocked
+	}
+	return encryption.Key(passphrase)
+}
+
+// Backend of the default store selected by TOKENDOKEY_STORE, file when unset
+func DefaultStoreBackend() string {
+	if backend := os.Getenv(StoreEnv); backend != "" {
+		return backend
+	}
+	return StoreFile
+}
+
+// Store of Load, Create, Clients and Remove, opened once per process. By default the files in
+// DefaultDir, encrypted when encryption is set up there. TOKENDOKEY_STORE=memory keeps clients in
+// memory, seeded from the environment, and TOKENDOKEY_STORE=secret-service in the Secret Service.
+func DefaultStore() (Store, error) {
+	defaultStoreMu.Lock()
+	defer defaultStoreMu.Unlock()
+	if defaultStore != nil {
+		return defaultStore, nil
+	}
+
+	var store Store
+	var err error
+	switch backend := DefaultStoreBackend(); backend {
+	case StoreFile:
+		var dir string
+		dir, err = DefaultDir()
+		if err != nil {
+			return nil, err
+		}
+		store, err = OpenStore(dir)
+	case StoreMemory:
+		store, err = MemoryStoreFromEnv(os.Environ())
+	case StoreSecretService:
+		store, err = NewSecretServiceStore()
+	default:
+		err = fmt.Errorf("unknown %s: %s", StoreEnv, backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defaultStore = store
+	return store, nil
+}
+
+// Replace the store of Load, Create, Clients and Remove
+func SetDefaultStore(store Store) {
+	defaultStoreMu.Lock()
+	defer defaultStoreMu.Unlock()
+	defaultStore = store
+}
+
+// Open the files in dir, unlocking them with UnlockFunc when they are encrypted
+func OpenStore(dir string) (Store, error) {
+	fileStore := &FileStore{Dir: dir}
+	encryption, err := LoadEncryption(dir)
+	if err != nil {
+		return nil, err
+	}
+	if encryption == nil {
+		return fileStore, nil
+	}
+
+	key, err := UnlockFunc(encryption)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedStore(fileStore, key)
+}
+
+func (s *FileStore) clientDir(client string) (string, error) {
+	if client == "" || client != filepath.Base(client) || client == "." || client == ".." {
+		return "", fmt.Errorf("invalid client name: %q", client)
+	}
+	return filepath.Join(s.Dir, client), nil
+}
+
+func (s *FileStore) path(client, key string) (string, error) {
+	dir, err := s.clientDir(client)
+	if err != nil {
+		return "", err
+	}
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmt.Errorf("invalid key: %q", key)
+	}
+	return filepath.Join(dir, filepath.FromSlash(key)), nil
+}
+
+func (s *FileStore) Get(client, key string) ([]byte, error) {
+	path, err := s.path(client, key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func (s *FileStore) Put(client, key string, data []byte) error {
+	path, err := s.path(client, key)
+	if err != nil {
+		return err
+	}
+	return WriteFile(path, data)
+}
+
+// Write a file only the current user can access, creating its folder. Data is written to a temporary
+// file renamed over the old one, so readers never see a partially written file.
+func WriteFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+func (s *FileStore) Remove(client, key string) error {
+	path, err := s.path(client, key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Directories of nested keys are removed with their last key, removing a directory that is not empty fails
+	dir, _ := s.clientDir(client)
+	for parent := filepath.Dir(path); parent != dir && os.Remove(parent) == nil; parent = filepath.Dir(parent) {
+	}
+	return nil
+}
+
+func (s *FileStore) Keys(client string) ([]string, error) {
+	dir, err := s.clientDir(client)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		// Lock and temporary files start with a dot
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return err
+		}
+		key, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(key))
+		return nil
+	})
+	return keys, err
+}
+
+// Names of the clients, none when Dir does not exist yet
+func (s *FileStore) List() ([]string, error) {
+	files, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		if file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *FileStore) Delete(client string) error {
+	dir, err := s.clientDir(client)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (s *FileStore) Lock(client string) (func(), error) {
+	dir, err := s.clientDir(client)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, lockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("locking client %s: %w", client, err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,112 @@
+package tokendokey
+
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Environment variables seeding the memory store, the rest of the name is the client name
+	configEnvPrefix       = "TOKENDOKEY_CONFIG_"
+	refreshTokenEnvPrefix = "TOKENDOKEY_REFRESH_TOKEN_"
+)
+
+// Store keeping everything in memory, for ephemeral environments like CI jobs
+type MemoryStore struct {
+	mu      sync.Mutex
+	clients map[string]map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{clients: map[string]map[string][]byte{}}
+}
+
+// Memory store with the clients configured in environ, a list of key=value pairs like os.Environ.
+// TOKENDOKEY_CONFIG_<NAME> holds the config.json of client <name> (lowercase) and
+// TOKENDOKEY_REFRESH_TOKEN_<NAME> its refresh or offline token.
+func MemoryStoreFromEnv(environ []string) (*MemoryStore, error) {
+	store := NewMemoryStore()
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		switch {
+		case strings.HasPrefix(name, configEnvPrefix) && len(name) > len(configEnvPrefix):
+			client := strings.ToLower(strings.TrimPrefix(name, configEnvPrefix))
+			err := store.Put(client, configFile, []byte(value))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		case strings.HasPrefix(name, refreshTokenEnvPrefix) && len(name) > len(refreshTokenEnvPrefix):
+			client := strings.ToLower(strings.TrimPrefix(name, refreshTokenEnvPrefix))
+			err := store.Put(client, refreshTokenFile, []byte(strings.TrimSpace(value)))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return store, nil
+}
+
+func (s *MemoryStore) Get(client, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.clients[client][key]
+	if !ok {
+		return nil, &fs.PathError{Op: "get", Path: client + "/" + key, Err: fs.ErrNotExist}
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (s *MemoryStore) Put(client, key string, data []byte) error {
+	if client == "" || key == "" {
+		return fmt.Errorf("invalid client name %q or key %q", client, key)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[client] == nil {
+		s.clients[client] = map[string][]byte{}
+	}
+	s.clients[client][key] = append([]byte{}, data...)
+	return nil
+}
+
+func (s *MemoryStore) Remove(client, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients[client], key)
+	return nil
+}
+
+func (s *MemoryStore) Keys(client string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.clients[client] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemoryStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *MemoryStore) Delete(client string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[client]; !ok {
+		return &fs.PathError{Op: "delete", Path: client, Err: fs.ErrNotExist}
+	}
+	delete(s.clients, client)
+	return nil
+}
@@ -0,0 +1,276 @@
+package tokendokey
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	secretServiceName       = "org.freedesktop.secrets"
+	secretServicePath       = "/org/freedesktop/secrets"
+	secretServiceInterface  = "org.freedesktop.Secret.Service"
+	secretCollectionIface   = "org.freedesktop.Secret.Collection"
+	secretItemInterface     = "org.freedesktop.Secret.Item"
+	secretPromptInterface   = "org.freedesktop.Secret.Prompt"
+	secretItemAttributes    = secretItemInterface + ".Attributes"
+	secretItemLabel         = secretItemInterface + ".Label"
+	secretServiceAttribute  = "application"
+	secretServiceAppName    = "tokendokey"
+	secretServiceNoPrompt   = dbus.ObjectPath("/")
+	secretServiceCollection = "default"
+)
+
+// Store keeping every key as an item of the default collection of the Secret Service
+// (GNOME Keyring, KWallet), found by the attributes application, client and key
+type SecretServiceStore struct {
+	conn       *dbus.Conn
+	session    dbus.ObjectPath
+	collection dbus.ObjectPath
+}
+
+// Secret of the Secret Service API
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Connect to the Secret Service on the session bus
+func NewSecretServiceStore() (*SecretServiceStore, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the session bus: %w", err)
+	}
+	service := conn.Object(secretServiceName, secretServicePath)
+
+	// Secrets travel unencrypted over the session bus, which only the current user can access
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err = service.Call(secretServiceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+	if err != nil {
+		return nil, fmt.Errorf("opening Secret Service session: %w", err)
+	}
+
+	var collection dbus.ObjectPath
+	err = service.Call(secretServiceInterface+".ReadAlias", 0, secretServiceCollection).Store(&collection)
+	if err != nil {
+		return nil, fmt.Errorf("reading default Secret Service collection: %w", err)
+	}
+	if collection == secretServiceNoPrompt {
+		return nil, fmt.Errorf("the Secret Service has no default collection")
+	}
+
+	return &SecretServiceStore{conn: conn, session: session, collection: collection}, nil
+}
+
+func secretAttributes(client, key string) map[string]string {
+	attributes := map[string]string{secretServiceAttribute: secretServiceAppName}
+	if client != "" {
+		attributes["client"] = client
+	}
+	if key != "" {
+		attributes["key"] = key
+	}
+	return attributes
+}
+
+// Items matching attributes, unlocked so their secrets can be read
+func (s *SecretServiceStore) search(attributes map[string]string) ([]dbus.ObjectPath, error) {
+	var items []dbus.ObjectPath
+	err := s.conn.Object(secretServiceName, s.collection).Call(secretCollectionIface+".SearchItems", 0, attributes).Store(&items)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	err = s.unlock(items)
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *SecretServiceStore) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := s.conn.Object(secretServiceName, secretServicePath).Call(secretServiceInterface+".Unlock", 0, objects).Store(&unlocked, &prompt)
+	if err != nil {
+		return err
+	}
+	return s.prompt(prompt)
+}
+
+// Let the Secret Service ask the user, e.g. for the keyring password, and wait for the answer
+func (s *SecretServiceStore) prompt(prompt dbus.ObjectPath) error {
+	if prompt == secretServiceNoPrompt || prompt == "" {
+		return nil
+	}
+
+	matchOptions := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(secretPromptInterface),
+		dbus.WithMatchMember("Completed"),
+	}
+	err := s.conn.AddMatchSignal(matchOptions...)
+	if err != nil {
+		return err
+	}
+	defer s.conn.RemoveMatchSignal(matchOptions...)
+	signals := make(chan *dbus.Signal, 1)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	err = s.conn.Object(secretServiceName, prompt).Call(secretPromptInterface+".Prompt", 0, "").Err
+	if err != nil {
+		return err
+	}
+	for signal := range signals {
+		if signal.Path != prompt || signal.Name != secretPromptInterface+".Completed" {
+			continue
+		}
+		if len(signal.Body) == 0 {
+			return errors.New("the Secret Service prompt completed without a result")
+		}
+		if dismissed, ok := signal.Body[0].(bool); ok && dismissed {
+			return errors.New("the Secret Service prompt was dismissed")
+		}
+		return nil
+	}
+	return errors.New("the session bus connection was closed")
+}
+
+func (s *SecretServiceStore) Get(client, key string) ([]byte, error) {
+	items, err := s.search(secretAttributes(client, key))
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, &fs.PathError{Op: "get", Path: client + "/" + key, Err: fs.ErrNotExist}
+	}
+
+	var value secret
+	err = s.conn.Object(secretServiceName, items[0]).Call(secretItemInterface+".GetSecret", 0, s.session).Store(&value)
+	if err != nil {
+		return nil, err
+	}
+	return value.Value, nil
+}
+
+func (s *SecretServiceStore) Put(client, key string, data []byte) error {
+	if client == "" || key == "" {
+		return fmt.Errorf("invalid client name %q or key %q", client, key)
+	}
+	err := s.unlock([]dbus.ObjectPath{s.collection})
+	if err != nil {
+		return err
+	}
+
+	properties := map[string]dbus.Variant{
+		secretItemLabel:      dbus.MakeVariant(secretServiceAppName + " " + client + " " + key),
+		secretItemAttributes: dbus.MakeVariant(secretAttributes(client, key)),
+	}
+	value := secret{Session: s.session, Value: data, ContentType: "text/plain"}
+	var item, prompt dbus.ObjectPath
+	err = s.conn.Object(secretServiceName, s.collection).Call(secretCollectionIface+".CreateItem", 0, properties, value, true).Store(&item, &prompt)
+	if err != nil {
+		return err
+	}
+	return s.prompt(prompt)
+}
+
+func (s *SecretServiceStore) Remove(client, key string) error {
+	items, err := s.search(secretAttributes(client, key))
+	if err != nil {
+		return err
+	}
+	return s.deleteItems(items)
+}
+
+func (s *SecretServiceStore) deleteItems(items []dbus.ObjectPath) error {
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		err := s.conn.Object(secretServiceName, item).Call(secretItemInterface+".Delete", 0).Store(&prompt)
+		if err != nil {
+			return err
+		}
+		err = s.prompt(prompt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Attributes of the items matching attributes
+func (s *SecretServiceStore) itemAttributes(attributes map[string]string) ([]map[string]string, error) {
+	var items []dbus.ObjectPath
+	err := s.conn.Object(secretServiceName, s.collection).Call(secretCollectionIface+".SearchItems", 0, attributes).Store(&items)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]string
+	for _, item := range items {
+		property, err := s.conn.Object(secretServiceName, item).GetProperty(secretItemAttributes)
+		if err != nil {
+			return nil, err
+		}
+		var itemAttributes map[string]string
+		err = property.Store(&itemAttributes)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, itemAttributes)
+	}
+	return result, nil
+}
+
+func (s *SecretServiceStore) Keys(client string) ([]string, error) {
+	items, err := s.itemAttributes(secretAttributes(client, ""))
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, attributes := range items {
+		keys = append(keys, attributes["key"])
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *SecretServiceStore) List() ([]string, error) {
+	items, err := s.itemAttributes(secretAttributes("", ""))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, attributes := range items {
+		if name := attributes["client"]; name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *SecretServiceStore) Delete(client string) error {
+	if client == "" {
+		return fmt.Errorf("client name is required")
+	}
+	items, err := s.search(secretAttributes(client, ""))
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return &fs.PathError{Op: "delete", Path: client, Err: fs.ErrNotExist}
+	}
+	return s.deleteItems(items)
+}
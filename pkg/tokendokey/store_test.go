@@ -0,0 +1,231 @@
+package tokendokey
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// Put, Get, Keys, List, Remove and Delete of one client in store
+func testStoreRoundTrip(t *testing.T, store Store, client string) {
+	t.Helper()
+
+	values := map[string][]byte{
+		configFile:                      []byte(`{"client_id":"test"}`),
+		refreshTokenFile:                []byte("refresh-token"),
+		"exchange/api/access_token.txt": []byte("exchanged-token"),
+	}
+	for key, data := range values {
+		if err := store.Put(client, key, data); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+	for key, want := range values {
+		got, err := store.Get(client, key)
+		if err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Get(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	// Overwriting replaces the whole value
+	if err := store.Put(client, refreshTokenFile, []byte("rotated")); err != nil {
+		t.Fatalf("Put(%q) again: %v", refreshTokenFile, err)
+	}
+	if got, _ := store.Get(client, refreshTokenFile); string(got) != "rotated" {
+		t.Errorf("Get(%q) after overwrite = %q, want %q", refreshTokenFile, got, "rotated")
+	}
+
+	keys, err := store.Keys(client)
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	slices.Sort(keys)
+	wantKeys := []string{"exchange/api/access_token.txt", configFile, refreshTokenFile}
+	slices.Sort(wantKeys)
+	if !slices.Equal(keys, wantKeys) {
+		t.Errorf("Keys = %q, want %q", keys, wantKeys)
+	}
+
+	names, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if !slices.Contains(names, client) {
+		t.Errorf("List = %q, missing %q", names, client)
+	}
+
+	if err := store.Remove(client, refreshTokenFile); err != nil {
+		t.Fatalf("Remove(%q): %v", refreshTokenFile, err)
+	}
+	if _, err := store.Get(client, refreshTokenFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get(%q) after Remove: err = %v, want fs.ErrNotExist", refreshTokenFile, err)
+	}
+	if err := store.Remove(client, refreshTokenFile); err != nil {
+		t.Errorf("Remove(%q) of a missing key: %v", refreshTokenFile, err)
+	}
+
+	if err := store.Delete(client); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(client, configFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get(%q) after Delete: err = %v, want fs.ErrNotExist", configFile, err)
+	}
+	names, _ = store.List()
+	if slices.Contains(names, client) {
+		t.Errorf("List after Delete = %q, still contains %q", names, client)
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store := &FileStore{Dir: t.TempDir()}
+	testStoreRoundTrip(t, store, "test")
+}
+
+func TestFileStorePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on Windows")
+	}
+	store := &FileStore{Dir: t.TempDir()}
+	if err := store.Put("test", "exchange/api/access_token.txt", []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		filepath.Join(store.Dir, "test", "exchange"):                            0700,
+		filepath.Join(store.Dir, "test", "exchange", "api", "access_token.txt"): 0600,
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("mode of %s = %o, want %o", path, got, want)
+		}
+	}
+}
+
+func TestFileStoreMissingDir(t *testing.T) {
+	store := &FileStore{Dir: filepath.Join(t.TempDir(), "missing")}
+	clients, err := store.List()
+	if err != nil || len(clients) != 0 {
+		t.Errorf("List() = %v, %v, want no clients", clients, err)
+	}
+}
+
+func TestFileStoreInvalidNames(t *testing.T) {
+	store := &FileStore{Dir: t.TempDir()}
+	for _, client := range []string{"", ".", "..", "a/b"} {
+		if err := store.Put(client, configFile, nil); err == nil {
+			t.Errorf("Put with client %q succeeded", client)
+		}
+	}
+	for _, key := range []string{"", ".", "../config.json", "/config.json"} {
+		if err := store.Put("test", key, nil); err == nil {
+			t.Errorf("Put with key %q succeeded", key)
+		}
+	}
+}
+
+func TestMemoryStoreRoundTrip(t *testing.T) {
+	testStoreRoundTrip(t, NewMemoryStore(), "test")
+}
+
+func TestMemoryStoreFromEnv(t *testing.T) {
+	store, err := MemoryStoreFromEnv([]string{
+		configEnvPrefix + "CI=" + `{"client_id":"ci"}`,
+		refreshTokenEnvPrefix + "CI=refresh-token",
+		"HOME=/home/test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := LoadFrom(store, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Config.ClientID != "ci" {
+		t.Errorf("ClientID = %q, want %q", client.Config.ClientID, "ci")
+	}
+	if got := client.RefreshToken(); got != "refresh-token" {
+		t.Errorf("RefreshToken = %q, want %q", got, "refresh-token")
+	}
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	encryption, key, err := NewEncryption("passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileStore := &FileStore{Dir: t.TempDir()}
+	store, err := NewEncryptedStore(fileStore, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStoreRoundTrip(t, store, "test")
+
+	// Values are sealed on disk
+	if err := store.Put("test", refreshTokenFile, []byte("refresh-token")); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := fileStore.Get("test", refreshTokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(sealed) || bytes.Contains(sealed, []byte("refresh-token")) {
+		t.Errorf("stored value %q is not encrypted", sealed)
+	}
+
+	// The passphrase derives the same key, another passphrase is rejected
+	derived, err := encryption.Key("passphrase")
+	if err != nil {
+		t.Fatalf("Key with the right passphrase: %v", err)
+	}
+	if !bytes.Equal(derived, key) {
+		t.Error("Key derived another key from the same passphrase")
+	}
+	if _, err := encryption.Key("wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Key with a wrong passphrase: err = %v, want ErrWrongPassphrase", err)
+	}
+
+	// Values are bound to their client and key
+	if err := fileStore.Put("test", accessTokenFile, sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("test", accessTokenFile); err == nil {
+		t.Error("Get of a value moved from another key succeeded")
+	}
+
+	wrongKey := make([]byte, len(key))
+	rand.Read(wrongKey)
+	wrongStore, _ := NewEncryptedStore(fileStore, wrongKey)
+	if _, err := wrongStore.Get("test", refreshTokenFile); err == nil {
+		t.Error("Get with a wrong key succeeded")
+	}
+}
+
+func TestSecretServiceStoreRoundTrip(t *testing.T) {
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		t.Skip("no D-Bus session bus")
+	}
+	store, err := NewSecretServiceStore()
+	if err != nil {
+		t.Skipf("no Secret Service: %v", err)
+	}
+
+	// A random client name, the test must not touch the user's clients
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	client := "tokendokey-test-" + hex.EncodeToString(suffix)
+	t.Cleanup(func() { store.Delete(client) })
+	testStoreRoundTrip(t, store, client)
+}
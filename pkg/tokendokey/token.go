@@ -0,0 +1,114 @@
+package tokendokey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Token endpoint response, successful or error, RFC 6749 section 5
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	IDToken          string `json:"id_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+	Scope            string `json:"scope"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	ErrorURI         string `json:"error_uri"`
+}
+
+// Post a token request and return the tokens, or an *OAuthError when the server refuses it
+func (c *Client) requestToken(ctx context.Context, httpClient *http.Client, endpoint string, form url.Values) (*tokenResponse, error) {
+	resp, err := c.postForm(ctx, httpClient, endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var tokens tokenResponse
+	json.Unmarshal(body, &tokens)
+
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" || tokens.AccessToken == "" {
+		return nil, &OAuthError{StatusCode: resp.StatusCode, Code: tokens.Error, Description: tokens.ErrorDescription, URI: tokens.ErrorURI}
+	}
+	return &tokens, nil
+}
+
+// Return the exp claim of a JWT, false for opaque tokens or tokens without exp
+func TokenExpiry(tokenString string) (time.Time, bool) {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return time.Time{}, false
+	}
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		if exp, ok := claims["exp"].(float64); ok {
+			return time.Unix(int64(exp), 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Whether the access or refresh token is valid for a while longer according to its exp claim.
+// Clients also know the expiry of opaque tokens from their metadata.
+func IsTokenValid(tokenString string, tokenType string) bool {
+	return TokenValidityError(tokenString, tokenType) == nil
+}
+
+// Why IsTokenValid rejects the token, nil when it accepts it
+func TokenValidityError(tokenString string, tokenType string) error {
+	if tokenString == "" {
+		return errors.New("token is empty")
+	}
+	_, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return fmt.Errorf("token is not a JWT, its expiry is unknown: %w", err)
+	}
+	expirationTime, ok := TokenExpiry(tokenString)
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	return expiryError(expirationTime, tokenType)
+}
+
+// Why a token of tokenType expiring at expirationTime is not valid for a while longer, nil when it is
+func expiryError(expirationTime time.Time, tokenType string) error {
+	if expiresAfter(expirationTime, tokenType) {
+		return nil
+	}
+	remaining := time.Until(expirationTime).Round(time.Second)
+	if remaining <= 0 {
+		return fmt.Errorf("token expired %s ago", -remaining)
+	}
+	return fmt.Errorf("token expires in %s, within the %s renewal margin", remaining, validityMargin(tokenType))
+}
+
+// Whether a token of tokenType expiring at expirationTime is valid for a while longer
+func expiresAfter(expirationTime time.Time, tokenType string) bool {
+	return time.Now().Before(expirationTime.Add(-validityMargin(tokenType)))
+}
+
+// Time before expiry from which a token of tokenType is renewed
+func validityMargin(tokenType string) time.Duration {
+	switch tokenType {
+	case "access":
+		return 30 * time.Second
+	case "refresh":
+		return 1 * time.Minute
+	default:
+		return 30 * time.Second
+	}
+}
@@ -0,0 +1,30 @@
+package tokendokey
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+)
+
+type tokenSource struct {
+	ctx    context.Context
+	client *Client
+}
+
+// Adapt the client to oauth2.TokenSource, e.g. for oauth2.NewClient
+func (c *Client) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return &tokenSource{ctx: ctx, client: c}
+}
+
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	accessToken, err := s.client.Token(s.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &oauth2.Token{AccessToken: accessToken, TokenType: "Bearer"}
+	if expiry, ok := tokenExpiry(s.client.Metadata(), accessToken); ok {
+		token.Expiry = expiry
+	}
+	return token, nil
+}
@@ -0,0 +1,70 @@
+package tokendokey
+
+import (
+	"io"
+	"net/http"
+)
+
+// An http.RoundTripper sending requests with the access token of a client as bearer token.
+// A 401 response is retried once with a freshly refreshed token, concurrent requests rejected with
+// the same token share one refresh.
+type Transport struct {
+	Client *Client
+	// Sends the authorized requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	accessToken, err := t.Client.Token(ctx)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+
+	resp, err := t.base().RoundTrip(authorizedRequest(req, accessToken))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Retrying needs a fresh copy of the body
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	accessToken, err = t.Client.RefreshIfStale(ctx, accessToken)
+	if err != nil {
+		return resp, nil
+	}
+
+	retry := authorizedRequest(req, accessToken)
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return t.base().RoundTrip(retry)
+}
+
+// Copy of req carrying the bearer token, RoundTrippers must not modify the request
+func authorizedRequest(req *http.Request, accessToken string) *http.Request {
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set("Authorization", "Bearer "+accessToken)
+	return authorized
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
@@ -0,0 +1,267 @@
+package tokendokey
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Cached key set of the issuer, refetched when a token is signed with an unknown key
+const jwksFile = "jwks.json"
+
+// Tolerated clock difference to the issuer for nbf and iat
+const verifyLeeway = 30 * time.Second
+
+// Minimum time between fetches of the key set for tokens signed with an unknown key, so forged
+// tokens cannot make every verification hit the issuer
+const jwksRefetchInterval = 5 * time.Minute
+
+// Asymmetric algorithms accepted for token signatures, never none or HMAC
+var verifyMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// JSON Web Key Set published at the jwks_uri of the issuer, RFC 7517
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Key set cached with the client
+type cachedKeySet struct {
+	JWKS
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// Public key of a JWKS, RSA, EC or Ed25519
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP curve and coordinates
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Public key described by the JWK
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding n of key %s: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding e of key %s: %w", k.Kid, err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s of key %s", k.Crv, k.Kid)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decoding x of key %s: %w", k.Kid, err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("decoding y of key %s: %w", k.Kid, err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s of key %s", k.Crv, k.Kid)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid x of key %s", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s of key %s", k.Kty, k.Kid)
+}
+
+// Signing key with the given kid, the only signing key when kid is empty, false when there is none
+func (s *JWKS) key(kid string) (JWK, bool) {
+	var signingKeys []JWK
+	for _, key := range s.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if kid != "" && key.Kid == kid {
+			return key, true
+		}
+		signingKeys = append(signingKeys, key)
+	}
+	if kid == "" && len(signingKeys) == 1 {
+		return signingKeys[0], true
+	}
+	return JWK{}, false
+}
+
+// Fetch the key set at jwksURI
+func FetchJWKS(ctx context.Context, httpClient *http.Client, jwksURI string) (*JWKS, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", jwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching key set: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var jwks JWKS
+	err = json.Unmarshal(body, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("parsing key set: %w", err)
+	}
+	return &jwks, nil
+}
+
+// Fetch the key set of the issuer and cache it with the client
+func (c *Client) UpdateJWKS(ctx context.Context) (*JWKS, error) {
+	if c.Config.JWKSURI == "" {
+		return nil, fmt.Errorf("client %s has no jwks_uri, initialize it again to fetch it from the discovery document", c.Name)
+	}
+	jwks, err := FetchJWKS(ctx, c.httpClient(), c.Config.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := json.MarshalIndent(cachedKeySet{JWKS: *jwks, FetchedAt: time.Now().UTC()}, "", "  ")
+	err = c.Store.Put(c.Name, jwksFile, data)
+	if err != nil {
+		return nil, err
+	}
+	return jwks, nil
+}
+
+// Cached key set of the issuer and when it was fetched, fetched when there is none
+func (c *Client) cachedJWKS(ctx context.Context) (*JWKS, time.Time, error) {
+	data, err := c.Store.Get(c.Name, jwksFile)
+	if err == nil {
+		var keySet cachedKeySet
+		if json.Unmarshal(data, &keySet) == nil && len(keySet.Keys) > 0 {
+			return &keySet.JWKS, keySet.FetchedAt, nil
+		}
+	}
+	jwks, err := c.UpdateJWKS(ctx)
+	return jwks, time.Now(), err
+}
+
+// Verify the signature of a JWT issued to the client with the key set of the issuer, and its iss,
+// aud, nbf and exp claims. tokenType is access or id, ID tokens must name the client as audience.
+// A key set missing the signing key is fetched again, the issuer may have rotated its keys, at most
+// once per jwksRefetchInterval. Verification failures wrap ErrInvalidToken, failures to get the keys do not.
+func (c *Client) VerifyToken(ctx context.Context, token, tokenType string) (jwt.MapClaims, error) {
+	if c.Config.Issuer == "" {
+		return nil, fmt.Errorf("client %s has no issuer, initialize it again to fetch it from the discovery document", c.Name)
+	}
+	jwks, fetchedAt, err := c.cachedJWKS(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting key set: %w", err)
+	}
+
+	// Failure to fetch keys, kept apart from the parser's validation errors
+	var fetchErr error
+	refetched := false
+	keyFunc := func(parsed *jwt.Token) (interface{}, error) {
+		kid, _ := parsed.Header["kid"].(string)
+		key, ok := jwks.key(kid)
+		if !ok && !refetched && time.Since(fetchedAt) >= jwksRefetchInterval {
+			refetched = true
+			jwks, fetchErr = c.UpdateJWKS(ctx)
+			if fetchErr != nil {
+				return nil, fetchErr
+			}
+			key, ok = jwks.key(kid)
+		}
+		if !ok {
+			return nil, fmt.Errorf("no key %q in the key set of the issuer", kid)
+		}
+		return key.PublicKey()
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(verifyMethods), jwt.WithoutClaimsValidation())
+	_, err = parser.ParseWithClaims(token, claims, keyFunc)
+	if fetchErr != nil {
+		return nil, fmt.Errorf("getting key set: %w", fetchErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	err = c.verifyClaims(claims, tokenType, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// Check the claims of a token with a valid signature against the client configuration
+func (c *Client) verifyClaims(claims jwt.MapClaims, tokenType string, now time.Time) error {
+	if !claims.VerifyIssuer(c.Config.Issuer, true) {
+		issuer, _ := claims["iss"].(string)
+		return fmt.Errorf("issuer %q is not %q", issuer, c.Config.Issuer)
+	}
+
+	if tokenType == "id" {
+		// ID tokens are always issued to the client, whatever audience its access tokens have
+		if !claims.VerifyAudience(c.Config.ClientID, true) {
+			return fmt.Errorf("token is not issued for %q", c.Config.ClientID)
+		}
+	} else {
+		// Access tokens name the API as audience and the client in azp
+		audience := c.Config.Audience
+		if audience == "" {
+			audience = c.Config.ClientID
+		}
+		authorizedParty, _ := claims["azp"].(string)
+		if !claims.VerifyAudience(audience, true) && (c.Config.Audience != "" || authorizedParty != c.Config.ClientID) {
+			return fmt.Errorf("token is not issued for %q", audience)
+		}
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return errors.New("token has no exp claim")
+	}
+	if !claims.VerifyExpiresAt(now.Unix(), true) {
+		return errors.New("token is expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(verifyLeeway).Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package tokendokey
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testIssuer = "https://idp.example.com"
+
+// EC P-256 signing key of the test issuer
+type testSigningKey struct {
+	kid string
+	key *ecdsa.PrivateKey
+}
+
+func newTestSigningKey(t *testing.T, kid string) testSigningKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigningKey{kid: kid, key: key}
+}
+
+func (k testSigningKey) jwk() JWK {
+	return JWK{
+		Kty: "EC",
+		Kid: k.kid,
+		Use: "sig",
+		Alg: "ES256",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(k.key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(k.key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// Token with claims signed by the key, issued by testIssuer and valid for five minutes unless claims
+// say otherwise. Claims set to nil are left out.
+func (k testSigningKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	allClaims := jwt.MapClaims{"iss": testIssuer, "exp": time.Now().Add(5 * time.Minute).Unix()}
+	for name, value := range claims {
+		if value == nil {
+			delete(allClaims, name)
+		} else {
+			allClaims[name] = value
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, allClaims)
+	token.Header["kid"] = k.kid
+	signed, err := token.SignedString(k.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// Key set endpoint publishing the keys set with publish, counting its fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []JWK
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, keys ...testSigningKey) *jwksServer {
+	server := &jwksServer{}
+	server.publish(keys...)
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.fetches++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(JWKS{Keys: server.keys})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *jwksServer) publish(keys ...testSigningKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+	for _, key := range keys {
+		s.keys = append(s.keys, key.jwk())
+	}
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// Cache keys as the key set of the client, fetched at fetchedAt
+func cacheTestJWKS(t *testing.T, client *Client, fetchedAt time.Time, keys ...testSigningKey) {
+	t.Helper()
+	keySet := cachedKeySet{FetchedAt: fetchedAt}
+	for _, key := range keys {
+		keySet.Keys = append(keySet.Keys, key.jwk())
+	}
+	data, _ := json.Marshal(keySet)
+	if err := client.Store.Put(client.Name, jwksFile, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The issuer rotated its keys: a token signed with a new kid makes the client fetch the key set again
+func TestVerifyTokenKeyRotation(t *testing.T) {
+	oldKey, newKey := newTestSigningKey(t, "old"), newTestSigningKey(t, "new")
+	server := newJWKSServer(t, newKey)
+	client := newTestClient(t, "test", Config{ClientID: "test", Issuer: testIssuer, JWKSURI: server.URL})
+	cacheTestJWKS(t, client, time.Now().Add(-jwksRefetchInterval), oldKey)
+
+	if _, err := client.VerifyToken(context.Background(), newKey.sign(t, jwt.MapClaims{"aud": "test"}), "access"); err != nil {
+		t.Fatalf("token signed with the rotated key: %v", err)
+	}
+	if got := server.fetchCount(); got != 1 {
+		t.Errorf("key set fetched %d times, want 1", got)
+	}
+
+	// The fetched key set is cached, the next token needs no fetch
+	if _, err := client.VerifyToken(context.Background(), newKey.sign(t, jwt.MapClaims{"aud": "test"}), "access"); err != nil {
+		t.Fatal(err)
+	}
+	if got := server.fetchCount(); got != 1 {
+		t.Errorf("key set fetched %d times after caching it, want 1", got)
+	}
+}
+
+// Tokens signed with unknown keys must not make every verification fetch the key set
+func TestVerifyTokenUnknownKeyRefetchLimit(t *testing.T) {
+	knownKey, unknownKey := newTestSigningKey(t, "known"), newTestSigningKey(t, "unknown")
+	server := newJWKSServer(t, knownKey)
+	client := newTestClient(t, "test", Config{ClientID: "test", Issuer: testIssuer, JWKSURI: server.URL})
+	cacheTestJWKS(t, client, time.Now(), knownKey)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.VerifyToken(context.Background(), unknownKey.sign(t, jwt.MapClaims{"aud": "test"}), "access")
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("VerifyToken = %v, want ErrInvalidToken", err)
+		}
+	}
+	if got := server.fetchCount(); got != 0 {
+		t.Errorf("key set fetched %d times within the refetch interval, want 0", got)
+	}
+}
+
+func TestVerifyTokenClaims(t *testing.T) {
+	key := newTestSigningKey(t, "key")
+	server := newJWKSServer(t, key)
+
+	for _, test := range []struct {
+		name      string
+		audience  string
+		tokenType string
+		claims    jwt.MapClaims
+		valid     bool
+	}{
+		{"access token for the client", "", "access", jwt.MapClaims{"aud": "test"}, true},
+		{"access token for an API authorized to the client", "", "access", jwt.MapClaims{"aud": "api", "azp": "test"}, true},
+		{"access token for another client", "", "access", jwt.MapClaims{"aud": "api", "azp": "other"}, false},
+		{"access token for the configured audience", "api", "access", jwt.MapClaims{"aud": []string{"api", "other"}}, true},
+		{"access token for the client instead of the configured audience", "api", "access", jwt.MapClaims{"aud": "test", "azp": "test"}, false},
+		{"ID token for the client", "api", "id", jwt.MapClaims{"aud": "test"}, true},
+		{"ID token for the audience of access tokens", "api", "id", jwt.MapClaims{"aud": "api", "azp": "test"}, false},
+		{"other issuer", "", "access", jwt.MapClaims{"aud": "test", "iss": "https://other.example.com"}, false},
+		{"expired", "", "access", jwt.MapClaims{"aud": "test", "exp": time.Now().Add(-time.Minute).Unix()}, false},
+		{"no exp", "", "access", jwt.MapClaims{"aud": "test", "exp": nil}, false},
+		{"not valid yet", "", "access", jwt.MapClaims{"aud": "test", "nbf": time.Now().Add(time.Hour).Unix()}, false},
+		{"nbf within the leeway", "", "access", jwt.MapClaims{"aud": "test", "nbf": time.Now().Add(verifyLeeway / 2).Unix()}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, "test", Config{ClientID: "test", Audience: test.audience, Issuer: testIssuer, JWKSURI: server.URL})
+			_, err := client.VerifyToken(context.Background(), key.sign(t, test.claims), test.tokenType)
+			if test.valid && err != nil {
+				t.Errorf("VerifyToken = %v, want valid", err)
+			}
+			if !test.valid && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("VerifyToken = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+// Tokens must be signed with a key of the issuer, none and HMAC with a public key as secret are refused
+func TestVerifyTokenAlgorithms(t *testing.T) {
+	key := newTestSigningKey(t, "key")
+	server := newJWKSServer(t, key)
+	client := newTestClient(t, "test", Config{ClientID: "test", Issuer: testIssuer, JWKSURI: server.URL})
+	claims := jwt.MapClaims{"iss": testIssuer, "aud": "test", "exp": time.Now().Add(5 * time.Minute).Unix()}
+
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = key.kid
+	hmacSigned, _ := hmac.SignedString([]byte(key.jwk().X))
+	for name, token := range map[string]string{"none": unsigned, "HS256": hmacSigned} {
+		if _, err := client.VerifyToken(context.Background(), token, "access"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("VerifyToken of a token signed with %s = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}